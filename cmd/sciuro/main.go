@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"time"
 
 	"github.com/caarlos0/env/v9"
 	"github.com/cloudflare/sciuro/internal/alert"
+	"github.com/cloudflare/sciuro/internal/leader"
 	"github.com/cloudflare/sciuro/internal/node"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	clientconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -18,17 +27,64 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/yaml"
 )
 
 type config struct {
 	// AlertmanagerURL is the url for the Alertmanager instance to sync from
 	AlertmanagerURL string `env:"SCIURO_ALERTMANAGER_URL"`
+	// AlertmanagerURLs is a list of urls of the peers of an Alertmanager cluster to
+	// sync from. Alerts are valid as long as one of them answers.
+	AlertmanagerURLs []string `env:"SCIURO_ALERTMANAGER_URLS"`
 	// PrometheusURLs is a list of Prometheus urls to sync from
 	PrometheusURLs []string `env:"SCIURO_PROMETHEUS_URLS"`
+	// PrometheusSourceTimeout is the maximum time given to each of PrometheusURLs to
+	// return its alerts. A value of 0 leaves only AlertFetchTimeout.
+	PrometheusSourceTimeout time.Duration `env:"SCIURO_PROMETHEUS_SOURCE_TIMEOUT" envDefault:"20s"`
+	// PrometheusQuorum is the minimum number of PrometheusURLs that must return their
+	// alerts for a fetch to succeed
+	PrometheusQuorum int `env:"SCIURO_PROMETHEUS_QUORUM" envDefault:"1"`
 	// MetricsAddr is the address and port to serve metrics from
 	MetricsAddr string `env:"SCIURO_METRICS_ADDR" envDefault:"0.0.0.0:8080"`
 	// AlertCacheTTL is the time between fetching alerts
 	AlertCacheTTL time.Duration `env:"SCIURO_ALERT_CACHE_TTL" envDefault:"60s"`
+	// AlertFetchTimeout is the maximum time given to fetch alerts
+	AlertFetchTimeout time.Duration `env:"SCIURO_ALERT_FETCH_TIMEOUT" envDefault:"30s"`
+	// AlertStaleness is how long the alerts of the last successful fetch are served
+	// while fetches fail. A value of 0 applies FetchErrorPolicy straight away.
+	AlertStaleness time.Duration `env:"SCIURO_ALERT_STALENESS" envDefault:"0s"`
+	// FetchErrorPolicy decides what happens to conditions once alerts are unavailable
+	// for longer than AlertStaleness: "unknown" sets them to Unknown with the error as
+	// message, "keep" leaves them as they were.
+	FetchErrorPolicy node.FetchErrorPolicy `env:"SCIURO_FETCH_ERROR_POLICY" envDefault:"unknown"`
+	// WatchdogAlert is the name of an always firing alert. A fetch that does not return
+	// it is treated as failed. An empty value disables the check.
+	WatchdogAlert string `env:"SCIURO_WATCHDOG_ALERT"`
+	// SuspiciousDropThreshold is the number of alerts after which a fetch returning no
+	// alerts is logged and counted as suspicious. A value of 0 disables the check.
+	SuspiciousDropThreshold int `env:"SCIURO_SUSPICIOUS_DROP_THRESHOLD" envDefault:"10"`
+	// BlastRadiusMaxNodes is the number of nodes a condition type may become True on
+	// within BlastRadiusWindow before further nodes are held back. A value of 0 does
+	// not limit the number of nodes.
+	BlastRadiusMaxNodes int `env:"SCIURO_BLAST_RADIUS_MAX_NODES" envDefault:"0"`
+	// BlastRadiusMaxPercent is the percentage of nodes a condition type may become True
+	// on within BlastRadiusWindow before further nodes are held back. It is rounded up,
+	// so at least one node is admitted. A value of 0 does not limit the percentage of
+	// nodes.
+	BlastRadiusMaxPercent float64 `env:"SCIURO_BLAST_RADIUS_MAX_PERCENT" envDefault:"0"`
+	// BlastRadiusWindow is the time the blast radius limits apply to.
+	BlastRadiusWindow time.Duration `env:"SCIURO_BLAST_RADIUS_WINDOW" envDefault:"2m"`
+	// BlastRadiusAddr is the address and port the leader lists and acknowledges blocked
+	// condition types on. It only listens on loopback by default, so that reaching it
+	// takes a port-forward to the leader. An empty value disables the endpoint.
+	BlastRadiusAddr string `env:"SCIURO_BLAST_RADIUS_ADDR" envDefault:"127.0.0.1:8082"`
+	// BlastRadiusToken is a bearer token required by the blast radius endpoint. An empty
+	// value does not require one.
+	BlastRadiusToken string `env:"SCIURO_BLAST_RADIUS_TOKEN"`
+	// NodeSelector is a label selector limiting the nodes that are watched, matched
+	// to alerts and reconciled. Nodes that stop matching keep their conditions as
+	// they are. An empty value selects all nodes.
+	NodeSelector string `env:"SCIURO_NODE_SELECTOR"`
 	// NodeResync is the period at which a node fully syncs with the current alerts
 	NodeResync time.Duration `env:"SCIURO_NODE_RESYNC" envDefault:"2m"`
 	// DevMode toggles additional logging information
@@ -41,13 +97,25 @@ type config struct {
 	// AlertReceiver is the receiver to use for server-side filtering of alerts
 	// must be the same across all targeted nodes in the cluster
 	AlertReceiver string `env:"SCIURO_ALERT_RECEIVER"`
-	// AlertSilenced controls whether silenced alerts are retrieved from alertmanager
+	// AlertSilenced is deprecated in favour of SuppressedStatus. When SuppressedStatus
+	// is unset, true keeps the conditions of silenced and inhibited alerts True.
 	AlertSilenced bool `env:"SCIURO_ALERT_SILENCED" envDefault:"false"`
+	// SuppressedStatus is the status of conditions whose alerts are silenced or inhibited
+	// in Alertmanager: "True", "False" or "Unknown". It defaults to "False".
+	SuppressedStatus node.SuppressedStatus `env:"SCIURO_SUPPRESSED_STATUS"`
 	// CelExpression is a Common Expression Language expression that runs against each alert.
 	// `labels` is a map representing the prometheus labels of the alert.
 	// There are two other valid variables available for substitution:
 	// `FullName` and `ShortName` where `ShortName` is `FullName` up to the first . (dot)
-	CelExpression string `env:"SCIURO_CEL_EXPRESSION,required"`
+	// `node` exposes the `name`, `labels`, `annotations`, `addresses` and `providerID`
+	// of the Node being matched, without the sciuro.cloudflare.com/ annotations.
+	// `annotations`, `state`, `activeAt` (timestamp), `value` (double, NaN when unknown),
+	// `fingerprint` and `status` describe the alert itself, and `now` is the time the
+	// alerts were retrieved.
+	// The helper functions `hostOf`, `matchesNode`, `regexCapture` and `inCIDR` are
+	// described in the README.
+	// It is required unless Rules is set.
+	CelExpression string `env:"SCIURO_CEL_EXPRESSION"`
 	// LeaderElectionNamespace is the namespace where the leader election config map will be
 	// managed. Defaults to the current namespace.
 	LeaderElectionNamespace string `env:"SCIURO_LEADER_NAMESPACE"`
@@ -57,8 +125,154 @@ type config struct {
 	// with the False status. After this time, the condition will be removed entirely.
 	// A value of 0 will never remove these conditions.
 	LingerResolvedDuration time.Duration `env:"SCIURO_LINGER_DURATION" envDefault:"96h"`
+	// HeartbeatInterval is the time after which the heartbeat of conditions is written
+	// even though nothing else changed. Node status patches changing nothing but the
+	// heartbeat are skipped until then. A value of 0 writes every heartbeat.
+	HeartbeatInterval time.Duration `env:"SCIURO_HEARTBEAT_INTERVAL" envDefault:"10m"`
+	// FiringObservations is the number of consecutive syncs an alert must be observed
+	// firing in before its condition becomes True. Alerts pushed to the webhook
+	// between syncs do not count as observations.
+	FiringObservations int `env:"SCIURO_FIRING_OBSERVATIONS" envDefault:"1"`
+	// MinFiringDuration is how long an alert must have been active before its condition
+	// becomes True.
+	MinFiringDuration time.Duration `env:"SCIURO_MIN_FIRING_DURATION" envDefault:"0s"`
+	// MinResolvedDuration is how long an alert must have been absent before its condition
+	// becomes False.
+	MinResolvedDuration time.Duration `env:"SCIURO_MIN_RESOLVED_DURATION" envDefault:"0s"`
 	// NodeConditionPrefix is the prefix for type of node condition.
+	// It is ignored when Rules is set.
 	NodeConditionPrefix string `env:"SCIURO_NODE_CONDITION_PREFIX" envDefault:"AlertManager_"`
+	// PriorityLabel is the alert label holding the priority of an alert. A lower
+	// priority is more important.
+	PriorityLabel string `env:"SCIURO_PRIORITY_LABEL" envDefault:"priority"`
+	// PriorityMapping maps values of the priority label to priorities, e.g.
+	// critical:1,warning:5,info:9. Values that are not mapped must be integers.
+	PriorityMapping map[string]int `env:"SCIURO_PRIORITY_MAPPING"`
+	// DefaultPriority is the priority of alerts without the priority label, or
+	// whose priority is malformed.
+	DefaultPriority int `env:"SCIURO_DEFAULT_PRIORITY" envDefault:"9"`
+	// ConditionTypeTemplate is a text/template over .Labels, .Annotations and .Priority
+	// rendering the condition type of a firing alert, after the condition prefix.
+	// Characters that are not valid in a condition type are replaced with _.
+	// Defaults to the alertname.
+	ConditionTypeTemplate string `env:"SCIURO_CONDITION_TYPE_TEMPLATE"`
+	// ConditionReasonTemplate renders the condition reason of a firing alert as for
+	// ConditionTypeTemplate. Defaults to AlertIsFiring.
+	ConditionReasonTemplate string `env:"SCIURO_CONDITION_REASON_TEMPLATE"`
+	// ConditionMessageTemplate renders the condition message of a firing alert as for
+	// ConditionTypeTemplate. Defaults to the priority followed by the summary annotation.
+	ConditionMessageTemplate string `env:"SCIURO_CONDITION_MESSAGE_TEMPLATE"`
+	// Taints maps the taint effects NoSchedule and PreferNoSchedule to the highest
+	// priority whose True conditions taint the node with that effect, e.g.
+	// NoSchedule:2,PreferNoSchedule:5. When empty, nodes are not tainted.
+	Taints node.Taints `env:"SCIURO_TAINTS"`
+	// Drain toggles cordoning nodes with True conditions of priority DrainMaxPriority or
+	// lower and evicting their pods. Nodes are uncordoned once the conditions clear,
+	// unless they were cordoned by someone else.
+	Drain bool `env:"SCIURO_DRAIN" envDefault:"false"`
+	// DrainMaxPriority is the highest priority whose True conditions drain the node.
+	DrainMaxPriority int `env:"SCIURO_DRAIN_MAX_PRIORITY" envDefault:"1"`
+	// DrainMaxConcurrent is the number of nodes drained at once across the cluster.
+	// It must be at least 1.
+	DrainMaxConcurrent int `env:"SCIURO_DRAIN_MAX_CONCURRENT" envDefault:"1"`
+	// DrainTimeout is the time after which draining a node gives up evicting pods,
+	// leaving it cordoned. A value of 0 keeps evicting.
+	DrainTimeout time.Duration `env:"SCIURO_DRAIN_TIMEOUT" envDefault:"30m"`
+	// DrainEvictUnmanaged evicts pods without a controller too, which deletes them for
+	// good. Otherwise they are left on the node.
+	DrainEvictUnmanaged bool `env:"SCIURO_DRAIN_EVICT_UNMANAGED" envDefault:"false"`
+	// Rules is a YAML list of named rules, each with its own CEL expression as for
+	// CelExpression, its own condition prefix and optionally a node selector limiting
+	// the nodes it applies to. Condition prefixes must not overlap. Rules may override
+	// the condition templates with typeTemplate, reasonTemplate and messageTemplate,
+	// and Taints with taints.
+	// When empty, a single rule named "default" is made of CelExpression and
+	// NodeConditionPrefix.
+	Rules rulesConfig `env:"SCIURO_RULES"`
+	// WebhookAddr is the address and port to receive Alertmanager webhook notifications on.
+	// Pushed alerts update the cache and affected nodes are reconciled immediately.
+	// An empty value disables the webhook receiver.
+	WebhookAddr string `env:"SCIURO_WEBHOOK_ADDR"`
+	// WebhookToken is the bearer token Alertmanager must send with webhook notifications.
+	// It is required when WebhookAddr is set.
+	WebhookToken string `env:"SCIURO_WEBHOOK_TOKEN"`
+	// PodName and PodNamespace name the pod of this replica, which is labeled while it
+	// leads so that the Service routes webhook notifications to the leader only. They
+	// are required when WebhookAddr is set, and are set through the downward API.
+	PodName      string `env:"SCIURO_POD_NAME"`
+	PodNamespace string `env:"SCIURO_POD_NAMESPACE"`
+}
+
+// ruleConfig is a rule as configured through SCIURO_RULES
+type ruleConfig struct {
+	Name            string      `json:"name"`
+	Expression      string      `json:"expression"`
+	ConditionPrefix string      `json:"conditionPrefix"`
+	NodeSelector    string      `json:"nodeSelector,omitempty"`
+	TypeTemplate    string      `json:"typeTemplate,omitempty"`
+	ReasonTemplate  string      `json:"reasonTemplate,omitempty"`
+	MessageTemplate string      `json:"messageTemplate,omitempty"`
+	Taints          node.Taints `json:"taints,omitempty"`
+}
+
+type rulesConfig []ruleConfig
+
+func (r *rulesConfig) UnmarshalText(text []byte) error {
+	// decode into the underlying slice, which is not a TextUnmarshaler
+	return yaml.UnmarshalStrict(text, (*[]ruleConfig)(r))
+}
+
+// rules returns the configured rules for the alert cache and the reconciler
+func (c *config) rules() ([]alert.Rule, []node.Rule, error) {
+	configured := c.Rules
+	if len(configured) == 0 {
+		if c.CelExpression == "" {
+			return nil, nil, errors.New("either SCIURO_RULES or SCIURO_CEL_EXPRESSION must be set")
+		}
+		configured = rulesConfig{{Name: "default", Expression: c.CelExpression, ConditionPrefix: c.NodeConditionPrefix}}
+	} else if c.CelExpression != "" {
+		return nil, nil, errors.New("SCIURO_RULES and SCIURO_CEL_EXPRESSION cannot both be set")
+	}
+
+	alertRules := make([]alert.Rule, 0, len(configured))
+	nodeRules := make([]node.Rule, 0, len(configured))
+	for _, rc := range configured {
+		var selector labels.Selector
+		if rc.NodeSelector != "" {
+			var err error
+			selector, err = labels.Parse(rc.NodeSelector)
+			if err != nil {
+				return nil, nil, fmt.Errorf("rule %q: invalid node selector: %w", rc.Name, err)
+			}
+		}
+		templates, err := node.ParseConditionTemplates(
+			firstNonEmpty(rc.TypeTemplate, c.ConditionTypeTemplate, node.DefaultTypeTemplate),
+			firstNonEmpty(rc.ReasonTemplate, c.ConditionReasonTemplate, node.DefaultReasonTemplate),
+			firstNonEmpty(rc.MessageTemplate, c.ConditionMessageTemplate, node.DefaultMessageTemplate),
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rule %q: %w", rc.Name, err)
+		}
+		taints := rc.Taints
+		if taints == nil {
+			taints = c.Taints
+		}
+		alertRules = append(alertRules, alert.Rule{Name: rc.Name, Expression: rc.Expression, NodeSelector: selector})
+		nodeRules = append(nodeRules, node.Rule{Name: rc.Name, ConditionPrefix: rc.ConditionPrefix, Templates: templates, Taints: taints})
+	}
+	if err := node.ValidateRules(nodeRules); err != nil {
+		return nil, nil, err
+	}
+	return alertRules, nodeRules, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
 }
 
 const name = "sciuro"
@@ -75,33 +289,59 @@ func main() {
 	logf.SetLogger(zap.New(zap.UseDevMode(cfg.DevMode), zap.WriteTo(os.Stderr)))
 	entryLog := log.WithName("entrypoint")
 
+	alertRules, nodeRules, err := cfg.rules()
+	if err != nil {
+		entryLog.Error(err, "invalid rules")
+		os.Exit(1)
+	}
+
+	nodeSelector, err := labels.Parse(cfg.NodeSelector)
+	if err != nil {
+		entryLog.Error(err, "invalid node selector")
+		os.Exit(1)
+	}
+
 	mgr, err := manager.New(clientconfig.GetConfigOrDie(), manager.Options{
 		LeaderElection:          true,
 		LeaderElectionID:        cfg.LeaderElectionID,
 		LeaderElectionNamespace: cfg.LeaderElectionNamespace,
+		// the cache backs the watch of nodes as well as the nodes alerts are matched to
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Node{}: {Label: nodeSelector},
+			},
+		},
 	})
 	if err != nil {
 		entryLog.Error(err, "unable to set up overall controller manager")
 		os.Exit(1)
 	}
 
+	// nodes affected by alert changes between resyncs are sent here for reconciliation
+	nodeEvents := make(chan event.TypedGenericEvent[*corev1.Node], 1024)
+
 	var as alert.Syncer
 	{
 		var client alert.Client
+		alertmanagerURLs := cfg.AlertmanagerURLs
 		if cfg.AlertmanagerURL != "" {
+			alertmanagerURLs = append([]string{cfg.AlertmanagerURL}, alertmanagerURLs...)
+		}
+		if len(alertmanagerURLs) > 0 {
 			if cfg.AlertReceiver == "" {
 				entryLog.Error(err, "receiver must be set when using alertmanager")
 				os.Exit(1)
 			}
 			var err error
-			client, err = alert.NewAlertmanagerClient(cfg.AlertmanagerURL, cfg.AlertReceiver, cfg.AlertSilenced)
+			client, err = alert.NewAlertmanagerMultiClient(alertmanagerURLs, cfg.AlertReceiver)
 			if err != nil {
 				entryLog.Error(err, "unable to setup alertmanager client")
 				os.Exit(1)
 			}
 		} else if cfg.PrometheusURLs != nil {
 			var err error
-			client, err = alert.NewPrometheusMultiClient(cfg.PrometheusURLs)
+			client, err = alert.NewPrometheusMultiClient(cfg.PrometheusURLs, log.WithName("prometheus"), metrics.Registry,
+				cfg.PrometheusSourceTimeout, cfg.PrometheusQuorum)
 			if err != nil {
 				entryLog.Error(err, "unable to setup prometheus api client(s)")
 				os.Exit(1)
@@ -114,8 +354,16 @@ func main() {
 			client,
 			log.WithName("syncer"),
 			metrics.Registry,
-			cfg.CelExpression,
-			cfg.AlertCacheTTL,
+			alertRules,
+			alert.SyncerOptions{
+				SyncInterval:  cfg.AlertCacheTTL,
+				FetchTimeout:  cfg.AlertFetchTimeout,
+				Staleness:     cfg.AlertStaleness,
+				Watchdog:      cfg.WatchdogAlert,
+				DropThreshold: cfg.SuspiciousDropThreshold,
+				Nodes:         mgr.GetCache(),
+				Events:        nodeEvents,
+			},
 		)
 		if err != nil {
 			entryLog.Error(err, "unable to parse template")
@@ -129,16 +377,111 @@ func main() {
 		}
 	}
 
+	if cfg.WebhookAddr != "" {
+		if cfg.WebhookToken == "" {
+			entryLog.Error(nil, "webhook token must be set when using the webhook receiver")
+			os.Exit(1)
+		}
+		if cfg.PodName == "" || cfg.PodNamespace == "" {
+			entryLog.Error(nil, "pod name and namespace must be set when using the webhook receiver")
+			os.Exit(1)
+		}
+		// the Service only selects the pod of the leader, which is the only one serving
+		labeler := leader.NewPodLabeler(mgr.GetClient(), log.WithName("leader"),
+			types.NamespacedName{Namespace: cfg.PodNamespace, Name: cfg.PodName})
+		if err := labeler.Unlabel(context.Background()); err != nil {
+			entryLog.Error(err, "unable to remove the leader label from the pod")
+			os.Exit(1)
+		}
+		if err := mgr.Add(labeler); err != nil {
+			entryLog.Error(err, "unable to add leader labeler to mgr")
+			os.Exit(1)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/webhook", alert.NewWebhookHandler(as, log.WithName("webhook"), metrics.Registry, cfg.AlertReceiver, cfg.WebhookToken))
+		// only the leader reconciles, so only the leader should accept pushed alerts
+		err := mgr.Add(&manager.Server{
+			Name:                "webhook",
+			Server:              &http.Server{Addr: cfg.WebhookAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+			OnlyServeWhenLeader: true,
+		})
+		if err != nil {
+			entryLog.Error(err, "unable to add webhook server to mgr")
+			os.Exit(1)
+		}
+	}
+
+	var guard *node.BlastRadiusGuard
+	if cfg.BlastRadiusMaxNodes > 0 || cfg.BlastRadiusMaxPercent > 0 {
+		guard = node.NewBlastRadiusGuard(mgr.GetClient(), log.WithName("blast-radius"), metrics.Registry, node.BlastRadius{
+			MaxNodes:   cfg.BlastRadiusMaxNodes,
+			MaxPercent: cfg.BlastRadiusMaxPercent,
+			Window:     cfg.BlastRadiusWindow,
+		}, cfg.BlastRadiusToken)
+	}
+	if guard != nil && cfg.BlastRadiusAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/blast-radius", guard)
+		// the guard only knows what the leader reconciled
+		err := mgr.Add(&manager.Server{
+			Name:                "blast-radius",
+			Server:              &http.Server{Addr: cfg.BlastRadiusAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+			OnlyServeWhenLeader: true,
+		})
+		if err != nil {
+			entryLog.Error(err, "unable to add blast radius server to mgr")
+			os.Exit(1)
+		}
+	}
+
+	var drainer *node.Drainer
+	if cfg.Drain {
+		if cfg.DrainMaxConcurrent < 1 {
+			entryLog.Error(nil, "drain max concurrent must be at least 1 when draining")
+			os.Exit(1)
+		}
+		drainer = node.NewDrainer(mgr.GetClient(), mgr.GetAPIReader(), log.WithName("drainer"), metrics.Registry,
+			mgr.GetEventRecorderFor(name), node.Drain{
+				MaxPriority:    cfg.DrainMaxPriority,
+				MaxConcurrent:  cfg.DrainMaxConcurrent,
+				Timeout:        cfg.DrainTimeout,
+				EvictUnmanaged: cfg.DrainEvictUnmanaged,
+			})
+	}
+
+	suppressedStatus := cfg.SuppressedStatus
+	if suppressedStatus == "" && cfg.AlertSilenced {
+		suppressedStatus = node.SuppressedStatus(corev1.ConditionTrue)
+	}
+
 	{
 		r := node.NewNodeStatusReconciler(
 			mgr.GetClient(),
 			log.WithName("reconciler"),
 			metrics.Registry,
-			cfg.NodeResync,
-			cfg.ReconcileTimeout,
-			cfg.LingerResolvedDuration,
+			mgr.GetEventRecorderFor(name),
 			as,
-			cfg.NodeConditionPrefix,
+			node.ReconcilerOptions{
+				ResyncInterval:    cfg.NodeResync,
+				ReconcileTimeout:  cfg.ReconcileTimeout,
+				Linger:            cfg.LingerResolvedDuration,
+				HeartbeatInterval: cfg.HeartbeatInterval,
+				Rules:             nodeRules,
+				Priorities: node.Priorities{
+					Label:   cfg.PriorityLabel,
+					Mapping: cfg.PriorityMapping,
+					Default: cfg.DefaultPriority,
+				},
+				Damping: node.Damping{
+					FiringObservations:  cfg.FiringObservations,
+					MinFiringDuration:   cfg.MinFiringDuration,
+					MinResolvedDuration: cfg.MinResolvedDuration,
+				},
+				FetchErrorPolicy: cfg.FetchErrorPolicy,
+				SuppressedStatus: suppressedStatus,
+				Guard:            guard,
+				Drainer:          drainer,
+			},
 		)
 
 		c, err := controller.New("node-status-controller", mgr, controller.Options{
@@ -155,6 +498,12 @@ func main() {
 			entryLog.Error(err, "unable to watch Nodes")
 			os.Exit(1)
 		}
+
+		// Enqueue nodes whose alerts changed between resyncs
+		if err := c.Watch(source.Channel(nodeEvents, &handler.TypedEnqueueRequestForObject[*corev1.Node]{})); err != nil {
+			entryLog.Error(err, "unable to watch alert changes")
+			os.Exit(1)
+		}
 	}
 
 	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
@@ -0,0 +1,96 @@
+package alert
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// Alert is a firing alert along with the metadata of the source it was
+// retrieved from
+type Alert struct {
+	promv1.Alert
+	// Fingerprint identifies the alert in Alertmanager. It is empty for alerts
+	// retrieved from Prometheus.
+	Fingerprint string
+	// Status is the Alertmanager state of the alert: active, suppressed or
+	// unprocessed. It is empty for alerts retrieved from Prometheus.
+	Status string
+	// SilencedBy are the IDs of the Alertmanager silences of a suppressed alert
+	SilencedBy []string
+	// InhibitedBy are the fingerprints of the Alertmanager alerts inhibiting a
+	// suppressed alert
+	InhibitedBy []string
+}
+
+// Silenced returns true if the alert is silenced in Alertmanager
+func (al Alert) Silenced() bool {
+	return len(al.SilencedBy) > 0
+}
+
+// Inhibited returns true if the alert is inhibited in Alertmanager
+func (al Alert) Inhibited() bool {
+	return len(al.InhibitedBy) > 0
+}
+
+const (
+	annotationsVar = "annotations"
+	stateVar       = "state"
+	activeAtVar    = "activeAt"
+	valueVar       = "value"
+	fingerprintVar = "fingerprint"
+	statusVar      = "status"
+	nowVar         = "now"
+)
+
+// vars returns the variables describing the alert itself to an expression.
+// now is the time the alerts were retrieved.
+func (al Alert) vars(now time.Time) map[string]any {
+	return map[string]any{
+		labelsVar:      al.Labels,
+		annotationsVar: al.Annotations,
+		stateVar:       string(al.State),
+		activeAtVar:    al.ActiveAt,
+		valueVar:       parseValue(al.Value),
+		fingerprintVar: al.Fingerprint,
+		statusVar:      al.Status,
+		nowVar:         now,
+	}
+}
+
+// parseValue converts the sample value of an alert to a float, which is NaN
+// for alerts without a value such as those from Alertmanager
+func parseValue(value string) float64 {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return parsed
+}
+
+// EvaluationError is returned by Cache.Get alongside the alerts that matched a
+// node when the rule could not be evaluated for some of the other alerts
+type EvaluationError struct {
+	Failures []AlertError
+}
+
+// AlertError is an alert that could not be evaluated
+type AlertError struct {
+	Alert Alert
+	Err   error
+}
+
+func (e *EvaluationError) Error() string {
+	return fmt.Sprintf("could not evaluate %d alert(s), first error: %v", len(e.Failures), e.Failures[0].Err)
+}
+
+func (e *EvaluationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
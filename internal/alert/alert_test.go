@@ -0,0 +1,72 @@
+package alert
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_syncer_Get_alertVars(t *testing.T) {
+	activeAt := time.Now().Add(-time.Hour)
+	al := Alert{
+		Alert: promv1.Alert{
+			State:       promv1.AlertStateFiring,
+			Labels:      model.LabelSet{"alertname": "NodeOnFire", "instance": "node1"},
+			Annotations: model.LabelSet{"summary": "Node has erupted into fire at 500C", "runbook": "https://example.com/fire"},
+			ActiveAt:    activeAt,
+			Value:       "500",
+		},
+		Fingerprint: "c4b4f8c1e9d8e2b4",
+		Status:      "active",
+	}
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{expression: `labels["instance"] == FullName && now - activeAt > duration("10m")`, want: true},
+		{expression: `labels["instance"] == FullName && now - activeAt > duration("2h")`, want: false},
+		{expression: `labels["instance"] == FullName && "runbook" in annotations`, want: true},
+		{expression: `labels["instance"] == FullName && annotations["summary"].contains("fire")`, want: true},
+		{expression: `labels["instance"] == FullName && value >= 400.0`, want: true},
+		{expression: `labels["instance"] == FullName && value < 400.0`, want: false},
+		{expression: `labels["instance"] == FullName && state == "firing"`, want: true},
+		{expression: `labels["instance"] == FullName && status == "suppressed"`, want: false},
+		{expression: `labels["instance"] == FullName && fingerprint == "c4b4f8c1e9d8e2b4"`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			s := newTestSyncer(t, tt.expression, []string{"node1"}, []Alert{al})
+			alerts, _, err := s.Get(namedNode("node1"), testRule)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, len(alerts) == 1)
+		})
+	}
+}
+
+func Test_parseValue(t *testing.T) {
+	assert.Equal(t, 1.5, parseValue("1.5"))
+	assert.Equal(t, 0.0, parseValue("0e+00"))
+	assert.True(t, math.IsNaN(parseValue("")))
+}
+
+func Test_syncer_Get_evaluationError(t *testing.T) {
+	matching := Alert{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "NodeOnFire", "node": "node1"}}}
+	unlabeled := Alert{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "HouseOnFire"}}}
+	s := newTestSyncer(t, `labels["node"] == FullName`, []string{"node1", "node2"}, []Alert{matching, unlabeled})
+
+	alerts, _, err := s.Get(namedNode("node1"), testRule)
+	assert.Equal(t, []Alert{matching}, alerts)
+	var evalErr *EvaluationError
+	assert.ErrorAs(t, err, &evalErr)
+	assert.Len(t, evalErr.Failures, 1)
+	assert.Equal(t, unlabeled, evalErr.Failures[0].Alert)
+	assert.EqualError(t, err, "could not evaluate 1 alert(s), first error: cel evaluation error: no such key: node")
+
+	alerts, _, err = s.Get(namedNode("node2"), testRule)
+	assert.Empty(t, alerts)
+	assert.ErrorAs(t, err, &evalErr)
+}
@@ -0,0 +1,176 @@
+package alert
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common"
+	celast "github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/parser"
+)
+
+const (
+	hostOfFunc       = "hostOf"
+	matchesNodeFunc  = "matchesNode"
+	regexCaptureFunc = "regexCapture"
+	inCIDRFunc       = "inCIDR"
+)
+
+// library is the set of helper functions available to expressions:
+//
+//	hostOf(instance)          the host of an address, without its port
+//	matchesNode(value)        whether value is the full name, short name or an IP of the node
+//	regexCapture(s, re, idx)  the idx-th capture group of re in s, or "" if it does not match
+//	inCIDR(ip, cidr)          whether ip is within cidr
+type library struct {
+	regexps sync.Map
+}
+
+func (l *library) LibraryName() string {
+	return "sciuro"
+}
+
+func (l *library) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Function(hostOfFunc,
+			cel.Overload("hostOf_string", []*cel.Type{cel.StringType}, cel.StringType,
+				cel.UnaryBinding(hostOf))),
+		// matchesNode(value) expands to matchesNode(value, node), as the
+		// node is only reachable through the activation
+		cel.Macros(cel.GlobalMacro(matchesNodeFunc, 1, expandMatchesNode)),
+		cel.Function(matchesNodeFunc,
+			cel.Overload("matchesNode_string_map", []*cel.Type{cel.StringType, cel.MapType(cel.StringType, cel.DynType)}, cel.BoolType,
+				cel.BinaryBinding(matchesNode))),
+		cel.Function(regexCaptureFunc,
+			cel.Overload("regexCapture_string_string_int", []*cel.Type{cel.StringType, cel.StringType, cel.IntType}, cel.StringType,
+				cel.FunctionBinding(l.regexCapture))),
+		cel.Function(inCIDRFunc,
+			cel.Overload("inCIDR_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(inCIDR))),
+	}
+}
+
+func (l *library) ProgramOptions() []cel.ProgramOption {
+	return nil
+}
+
+func expandMatchesNode(eh parser.ExprHelper, _ celast.Expr, args []celast.Expr) (celast.Expr, *common.Error) {
+	return eh.NewCall(matchesNodeFunc, args[0], eh.NewIdent(nodeVar)), nil
+}
+
+// hostOf strips the port from an address such as the instance label of a
+// scrape target. Addresses without a port are returned unchanged.
+func hostOf(value ref.Val) ref.Val {
+	s, ok := value.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(value)
+	}
+	host, _, err := net.SplitHostPort(string(s))
+	if err != nil {
+		return types.String(strings.TrimSuffix(strings.TrimPrefix(string(s), "["), "]"))
+	}
+	return types.String(host)
+}
+
+func matchesNode(value, node ref.Val) ref.Val {
+	s, ok := value.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(value)
+	}
+	m, ok := node.(traits.Mapper)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(node)
+	}
+	name, ok := m.Get(types.String("name")).(types.String)
+	if !ok {
+		return types.NewErr("node has no name")
+	}
+	if s == name || string(s) == shortName(string(name)) {
+		return types.True
+	}
+	addresses, ok := m.Get(types.String("addresses")).(traits.Lister)
+	if !ok {
+		return types.False
+	}
+	for it := addresses.Iterator(); it.HasNext() == types.True; {
+		address, ok := it.Next().(traits.Mapper)
+		if !ok {
+			continue
+		}
+		if ip, ok := address.Get(types.String("address")).(types.String); ok && ip == s {
+			return types.True
+		}
+	}
+	return types.False
+}
+
+func (l *library) regexCapture(args ...ref.Val) ref.Val {
+	s, ok := args[0].(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(args[0])
+	}
+	pattern, ok := args[1].(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(args[1])
+	}
+	idx, ok := args[2].(types.Int)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(args[2])
+	}
+	re, err := l.compile(string(pattern))
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	if idx < 0 || int(idx) > re.NumSubexp() {
+		return types.NewErr("regexCapture: group %d out of range for %q", idx, pattern)
+	}
+	match := re.FindStringSubmatch(string(s))
+	if match == nil {
+		return types.String("")
+	}
+	return types.String(match[idx])
+}
+
+// compile caches compiled expressions, as the same pattern is evaluated
+// against every alert and node
+func (l *library) compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := l.regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("regexCapture: %w", err)
+	}
+	l.regexps.Store(pattern, re)
+	return re, nil
+}
+
+// inCIDR reports whether ip is within cidr. Values that are not IP addresses,
+// such as host names, are not within any range.
+func inCIDR(ip, cidr ref.Val) ref.Val {
+	s, ok := ip.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(ip)
+	}
+	c, ok := cidr.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(cidr)
+	}
+	prefix, err := netip.ParsePrefix(string(c))
+	if err != nil {
+		return types.NewErr("inCIDR: %s", err)
+	}
+	addr, err := netip.ParseAddr(string(s))
+	if err != nil {
+		return types.False
+	}
+	return types.Bool(prefix.Contains(addr.Unmap()))
+}
@@ -0,0 +1,85 @@
+package alert
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_library(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: v1.ObjectMeta{Name: "node1.example.com"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.1.2.3"},
+			{Type: corev1.NodeInternalIP, Address: "fd00::3"},
+		}},
+	}
+	tests := []struct {
+		expression string
+		want       any
+		wantErr    string
+	}{
+		{expression: `hostOf("node1.example.com:9100")`, want: "node1.example.com"},
+		{expression: `hostOf("10.1.2.3:9100")`, want: "10.1.2.3"},
+		{expression: `hostOf("[fd00::3]:9100")`, want: "fd00::3"},
+		{expression: `hostOf("[fd00::3]")`, want: "fd00::3"},
+		{expression: `hostOf("node1")`, want: "node1"},
+		{expression: `matchesNode("node1.example.com")`, want: true},
+		{expression: `matchesNode("node1")`, want: true},
+		{expression: `matchesNode("10.1.2.3")`, want: true},
+		{expression: `matchesNode(hostOf("[fd00::3]:9100"))`, want: true},
+		{expression: `matchesNode("node1.example.org")`, want: false},
+		{expression: `matchesNode("10.1.2.4")`, want: false},
+		{expression: `matchesNode("")`, want: false},
+		{expression: `regexCapture("node1.example.com", "^([^.]+)\\.(.+)$", 2)`, want: "example.com"},
+		{expression: `regexCapture("node1.example.com", "^([^.]+)\\.(.+)$", 0)`, want: "node1.example.com"},
+		{expression: `regexCapture("node1", "^([^.]+)\\.(.+)$", 1)`, want: ""},
+		{expression: `regexCapture("node1", "^(node)", 2)`, wantErr: `regexCapture: group 2 out of range for "^(node)"`},
+		{expression: `regexCapture("node1", "(", 1)`, wantErr: "regexCapture: error parsing regexp: missing closing ): `(`"},
+		{expression: `inCIDR("10.1.2.3", "10.0.0.0/8")`, want: true},
+		{expression: `inCIDR("10.1.2.3", "192.168.0.0/16")`, want: false},
+		{expression: `inCIDR("fd00::3", "fd00::/8")`, want: true},
+		{expression: `inCIDR("::ffff:10.1.2.3", "10.0.0.0/8")`, want: true},
+		{expression: `inCIDR("node1", "10.0.0.0/8")`, want: false},
+		{expression: `inCIDR("10.1.2.3", "10.0.0.0")`, wantErr: `inCIDR: netip.ParsePrefix("10.0.0.0"): no '/'`},
+	}
+	env, err := cel.NewEnv(
+		cel.Variable(nodeVar, cel.MapType(cel.StringType, cel.DynType)),
+		cel.Lib(&library{}),
+	)
+	assert.NoError(t, err)
+	vars := map[string]any{nodeVar: newNodeView(node, true).vars}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			ast, issues := env.Compile(tt.expression)
+			assert.NoError(t, issues.Err())
+			program, err := env.Program(ast)
+			assert.NoError(t, err)
+			out, _, err := program.Eval(vars)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.Value())
+		})
+	}
+}
+
+// Test_library_syncer checks that matchesNode sees the node being matched
+func Test_library_syncer(t *testing.T) {
+	alerts := []Alert{
+		{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "NodeOnFire", "instance": "node1:9100"}}},
+		{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "NodeOnFire", "instance": "node2:9100"}}},
+	}
+	s := newTestSyncer(t, `matchesNode(hostOf(labels["instance"]))`, []string{"node1.example.com"}, alerts)
+	assert.True(t, s.usesNode)
+	matched, _, err := s.Get(namedNode("node1.example.com"), testRule)
+	assert.NoError(t, err)
+	assert.Equal(t, alerts[:1], matched)
+}
@@ -0,0 +1,312 @@
+package alert
+
+import (
+	"strings"
+	"time"
+
+	celast "github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	labelsVar    = "labels"
+	fullNameVar  = "FullName"
+	shortNameVar = "ShortName"
+	nodeVar      = "node"
+
+	// ownAnnotationPrefix is the prefix of the annotations sciuro writes to nodes,
+	// which are hidden from expressions so that writing them does not change the
+	// view of the node
+	ownAnnotationPrefix = "sciuro.cloudflare.com/"
+)
+
+// nodeView is what an expression can see of a node
+type nodeView struct {
+	name string
+	// labels are used to select the rules applying to the node
+	labels labels.Set
+	// vars is the projection of the node exposed as `node`, which is only
+	// populated when the expression refers to it
+	vars map[string]any
+}
+
+func newNodeView(node *corev1.Node, withVars bool) *nodeView {
+	v := &nodeView{name: node.Name, labels: node.Labels}
+	if !withVars {
+		return v
+	}
+	addresses := make([]map[string]string, 0, len(node.Status.Addresses))
+	for _, address := range node.Status.Addresses {
+		addresses = append(addresses, map[string]string{
+			"type":    string(address.Type),
+			"address": address.Address,
+		})
+	}
+	v.vars = map[string]any{
+		"name":        node.Name,
+		"labels":      stringMap(node.Labels),
+		"annotations": foreignAnnotations(node.Annotations),
+		"addresses":   addresses,
+		"providerID":  node.Spec.ProviderID,
+	}
+	return v
+}
+
+// foreignAnnotations returns annotations without those sciuro writes
+func foreignAnnotations(annotations map[string]string) map[string]string {
+	foreign := annotations
+	for key := range annotations {
+		if strings.HasPrefix(key, ownAnnotationPrefix) {
+			foreign = make(map[string]string, len(annotations))
+			for key, value := range annotations {
+				if !strings.HasPrefix(key, ownAnnotationPrefix) {
+					foreign[key] = value
+				}
+			}
+			break
+		}
+	}
+	return stringMap(foreign)
+}
+
+func stringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+// activation returns the variables an alert is evaluated with against this node
+func (v *nodeView) activation(al Alert, now time.Time) map[string]any {
+	vars := al.vars(now)
+	vars[fullNameVar] = v.name
+	vars[shortNameVar] = shortName(v.name)
+	if v.vars != nil {
+		vars[nodeVar] = v.vars
+	}
+	return vars
+}
+
+// nodeSet is the set of nodes an index was built against
+type nodeSet struct {
+	names   []string
+	views   map[string]*nodeView
+	byShort map[string][]string
+}
+
+func newNodeSet(nodes []corev1.Node, withVars bool) *nodeSet {
+	ns := &nodeSet{
+		names:   make([]string, 0, len(nodes)),
+		views:   make(map[string]*nodeView, len(nodes)),
+		byShort: make(map[string][]string, len(nodes)),
+	}
+	for i := range nodes {
+		name := nodes[i].Name
+		ns.names = append(ns.names, name)
+		ns.views[name] = newNodeView(&nodes[i], withVars)
+		short := shortName(name)
+		ns.byShort[short] = append(ns.byShort[short], name)
+	}
+	return ns
+}
+
+func shortName(nodeName string) string {
+	return strings.Split(nodeName, ".")[0]
+}
+
+// referencesIdent reports whether expr refers to the variable name
+func referencesIdent(expr celast.Expr, name string) bool {
+	found := false
+	celast.PostOrderVisit(expr, celast.NewExprVisitor(func(e celast.Expr) {
+		found = found || isIdent(e, name)
+	}))
+	return found
+}
+
+// nodeAlerts are the alerts matched to a single node
+type nodeAlerts struct {
+	alerts []Alert
+	failed []failedMatch
+}
+
+// failedMatch is an alert that could not be evaluated against a node
+type failedMatch struct {
+	alert Alert
+	err   error
+}
+
+// index holds the matched alerts of every node in a nodeSet, keyed by node name.
+// It is only valid for the rule it was built with.
+type index map[string]*nodeAlerts
+
+func (idx index) add(nodeName string, al Alert) {
+	idx[nodeName].alerts = append(idx[nodeName].alerts, al)
+}
+
+func (idx index) fail(nodeName string, al Alert, err error) {
+	idx[nodeName].failed = append(idx[nodeName].failed, failedMatch{alert: al, err: err})
+}
+
+// labelEquality is the fast path for expressions that are a disjunction of label
+// comparisons against FullName or ShortName, optionally guarded by label presence,
+// such as:
+//
+//	"node" in labels && (labels["node"] == FullName || labels["node"] == ShortName)
+//
+// These can be answered with map lookups instead of a CEL evaluation per node.
+type labelEquality struct {
+	guards []model.LabelName
+	terms  []equalityTerm
+}
+
+type equalityTerm struct {
+	label model.LabelName
+	short bool
+}
+
+// parseLabelEquality returns the fast path for expr, or nil if it does not apply
+func parseLabelEquality(expr celast.Expr) *labelEquality {
+	le := &labelEquality{}
+	if !le.parseConjunction(expr) || len(le.terms) == 0 {
+		return nil
+	}
+	return le
+}
+
+func (le *labelEquality) parseConjunction(expr celast.Expr) bool {
+	if label, ok := parseGuard(expr); ok {
+		le.guards = append(le.guards, label)
+		return true
+	}
+	if isCall(expr, operators.LogicalAnd) {
+		args := expr.AsCall().Args()
+		return le.parseConjunction(args[0]) && le.parseConjunction(args[1])
+	}
+	// only a single disjunction may be guarded
+	if len(le.terms) != 0 {
+		return false
+	}
+	return le.parseDisjunction(expr)
+}
+
+func (le *labelEquality) parseDisjunction(expr celast.Expr) bool {
+	if isCall(expr, operators.LogicalOr) {
+		args := expr.AsCall().Args()
+		return le.parseDisjunction(args[0]) && le.parseDisjunction(args[1])
+	}
+	if !isCall(expr, operators.Equals) {
+		return false
+	}
+	args := expr.AsCall().Args()
+	for _, pair := range [][2]celast.Expr{{args[0], args[1]}, {args[1], args[0]}} {
+		label, ok := parseLabelIndex(pair[0])
+		if !ok || pair[1].Kind() != celast.IdentKind {
+			continue
+		}
+		switch pair[1].AsIdent() {
+		case fullNameVar:
+			le.terms = append(le.terms, equalityTerm{label: label})
+			return true
+		case shortNameVar:
+			le.terms = append(le.terms, equalityTerm{label: label, short: true})
+			return true
+		}
+	}
+	return false
+}
+
+// parseGuard matches `"label" in labels`
+func parseGuard(expr celast.Expr) (model.LabelName, bool) {
+	if !isCall(expr, operators.In) {
+		return "", false
+	}
+	args := expr.AsCall().Args()
+	label, ok := stringLiteral(args[0])
+	return model.LabelName(label), ok && isIdent(args[1], labelsVar)
+}
+
+// parseLabelIndex matches `labels["label"]`
+func parseLabelIndex(expr celast.Expr) (model.LabelName, bool) {
+	if !isCall(expr, operators.Index) {
+		return "", false
+	}
+	args := expr.AsCall().Args()
+	label, ok := stringLiteral(args[1])
+	return model.LabelName(label), ok && isIdent(args[0], labelsVar)
+}
+
+func isCall(expr celast.Expr, function string) bool {
+	return expr.Kind() == celast.CallKind && expr.AsCall().FunctionName() == function && !expr.AsCall().IsMemberFunction()
+}
+
+func isIdent(expr celast.Expr, name string) bool {
+	return expr.Kind() == celast.IdentKind && expr.AsIdent() == name
+}
+
+func stringLiteral(expr celast.Expr) (string, bool) {
+	if expr.Kind() != celast.LiteralKind {
+		return "", false
+	}
+	s, ok := expr.AsLiteral().(types.String)
+	return string(s), ok
+}
+
+// nodes returns the names of the nodes in ns that al matches. ok is false when
+// the result depends on CEL error semantics, in which case CEL must decide.
+func (le *labelEquality) nodes(al Alert, ns *nodeSet) (names []string, ok bool) {
+	if !le.guarded(al) {
+		return nil, true
+	}
+	seen := make(map[string]struct{})
+	for _, term := range le.terms {
+		value, present := al.Labels[term.label]
+		if !present {
+			// indexing a missing label is an evaluation error
+			return nil, false
+		}
+		candidates := ns.byShort[string(value)]
+		if !term.short {
+			candidates = nil
+			if _, found := ns.views[string(value)]; found {
+				candidates = []string{string(value)}
+			}
+		}
+		for _, name := range candidates {
+			if _, dup := seen[name]; !dup {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
+	}
+	return names, true
+}
+
+// matches reports whether al matches nodeName, with ok as for nodes
+func (le *labelEquality) matches(al Alert, nodeName string) (matched, ok bool) {
+	if !le.guarded(al) {
+		return false, true
+	}
+	for _, term := range le.terms {
+		value, present := al.Labels[term.label]
+		if !present {
+			return false, false
+		}
+		if (term.short && string(value) == shortName(nodeName)) || (!term.short && string(value) == nodeName) {
+			matched = true
+		}
+	}
+	return matched, true
+}
+
+func (le *labelEquality) guarded(al Alert) bool {
+	for _, label := range le.guards {
+		if _, ok := al.Labels[label]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package alert
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/cel-go/cel"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_parseLabelEquality(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       *labelEquality
+	}{
+		{
+			expression: `labels["node"] == FullName`,
+			want:       &labelEquality{terms: []equalityTerm{{label: "node"}}},
+		},
+		{
+			expression: `ShortName == labels["node"]`,
+			want:       &labelEquality{terms: []equalityTerm{{label: "node", short: true}}},
+		},
+		{
+			expression: `"node" in labels && (labels["node"] == FullName || labels["node"] == ShortName)`,
+			want: &labelEquality{
+				guards: []model.LabelName{"node"},
+				terms:  []equalityTerm{{label: "node"}, {label: "node", short: true}},
+			},
+		},
+		{
+			expression: `labels["instance"] == FullName || labels["node"] == ShortName`,
+			want:       &labelEquality{terms: []equalityTerm{{label: "instance"}, {label: "node", short: true}}},
+		},
+		{expression: `"node" in labels`},
+		{expression: `labels["node"] == "node1"`},
+		{expression: `labels["node"] != FullName`},
+		{expression: `labels["node"] == FullName && labels["instance"] == FullName`},
+		{expression: `labels["node"] == FullName && labels["notify"].contains("node-condition-k8s")`},
+		{expression: `!(labels["node"] == FullName)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			env, err := cel.NewEnv(cel.Variable(labelsVar, cel.MapType(cel.StringType, cel.StringType)),
+				cel.Variable(fullNameVar, cel.StringType),
+				cel.Variable(shortNameVar, cel.StringType))
+			assert.NoError(t, err)
+			ast, issues := env.Compile(tt.expression)
+			assert.NoError(t, issues.Err())
+			assert.Equal(t, tt.want, parseLabelEquality(ast.NativeRep().Expr()))
+		})
+	}
+}
+
+// Test_syncer_index checks that the index, with and without the fast path,
+// agrees with evaluating the expression for every node
+func Test_syncer_index(t *testing.T) {
+	nodeNames := []string{"node1.example.com", "node2.example.com", "node2.example.org", "node3"}
+	alerts := []Alert{
+		{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "A", "node": "node1.example.com"}}},
+		{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "B", "node": "node2"}}},
+		{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "C", "instance": "node3"}}},
+		{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "D", "node": "node3", "instance": "node1.example.com"}}},
+		{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "E"}}},
+	}
+	expressions := []string{
+		`labels["node"] == FullName`,
+		`"node" in labels && (labels["node"] == FullName || labels["node"] == ShortName)`,
+		`labels["instance"] == FullName || labels["node"] == ShortName`,
+		`"instance" in labels && "node" in labels && labels["node"] == ShortName`,
+	}
+	for _, expression := range expressions {
+		t.Run(expression, func(t *testing.T) {
+			s := newTestSyncer(t, expression, nodeNames, alerts)
+			assert.NotNil(t, s.rules[0].fastPath)
+			fast := s.snapshot.Load().indexes[0]
+
+			s.rules[0].fastPath = nil
+			s.SyncOnce()
+			slow := s.snapshot.Load().indexes[0]
+
+			for _, name := range nodeNames {
+				want := s.match(s.rules[0], alerts, newNodeView(namedNode(name), false), time.Now())
+				assert.Equal(t, want.alerts, append([]Alert{}, slow[name].alerts...), name)
+				assert.Equal(t, len(want.failed), len(slow[name].failed), name)
+				assert.Equal(t, want.alerts, append([]Alert{}, fast[name].alerts...), name)
+				assert.Equal(t, len(want.failed), len(fast[name].failed), name)
+			}
+		})
+	}
+}
+
+func Test_newNodeView_ownAnnotations(t *testing.T) {
+	node := namedNode("node1")
+	node.Annotations = map[string]string{"rack": "r1"}
+	want := newNodeView(node, true)
+
+	annotated := node.DeepCopy()
+	annotated.Annotations["sciuro.cloudflare.com/damping"] = `{"AlertManager_NodeOnFire":{"firing":1}}`
+	annotated.Annotations["sciuro.cloudflare.com/cordoned"] = "true"
+	assert.Equal(t, want, newNodeView(annotated, true))
+	assert.Equal(t, map[string]string{"rack": "r1"}, newNodeView(annotated, true).vars["annotations"])
+	assert.Len(t, annotated.Annotations, 3)
+}
+
+func newTestSyncer(t testing.TB, expression string, nodeNames []string, alerts []Alert) *syncer {
+	objects := make([]client.Object, 0, len(nodeNames))
+	for _, name := range nodeNames {
+		objects = append(objects, &corev1.Node{ObjectMeta: v1.ObjectMeta{Name: name}})
+	}
+	mClient := &mockAlertClient{}
+	mClient.On("GetAlerts", mock.Anything).Return(alerts, false, nil)
+	s, err := NewSyncer(mClient, logr.Discard(), prometheus.NewRegistry(), singleRule(expression), SyncerOptions{SyncInterval: time.Minute, FetchTimeout: time.Minute, Nodes: fake.NewClientBuilder().WithObjects(objects...).Build()})
+	assert.NoError(t, err)
+	s.SyncOnce()
+	return s.(*syncer)
+}
+
+// BenchmarkSyncer_resync measures a sync followed by a Get for every node, as
+// happens on each resync, for 500 nodes and 200 alerts
+func BenchmarkSyncer_resync(b *testing.B) {
+	const numNodes, numAlerts = 500, 200
+	nodeNames := make([]string, 0, numNodes)
+	for i := 0; i < numNodes; i++ {
+		nodeNames = append(nodeNames, fmt.Sprintf("node%d.example.com", i))
+	}
+	alerts := make([]Alert, 0, numAlerts)
+	for i := 0; i < numAlerts; i++ {
+		alerts = append(alerts, Alert{Alert: promv1.Alert{Labels: model.LabelSet{
+			"alertname": "NodeOnFire",
+			"node":      model.LabelValue(fmt.Sprintf("node%d", i*3)),
+		}}})
+	}
+	const expression = `"node" in labels && (labels["node"] == FullName || labels["node"] == ShortName)`
+
+	nodes := make([]*corev1.Node, 0, numNodes)
+	for _, name := range nodeNames {
+		nodes = append(nodes, namedNode(name))
+	}
+	run := func(b *testing.B, s *syncer) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.SyncOnce()
+			for _, node := range nodes {
+				if _, _, err := s.Get(node, testRule); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+
+	// before: every Get evaluates the expression against every alert
+	b.Run("scan", func(b *testing.B) {
+		s := newTestSyncer(b, expression, nodeNames, alerts)
+		s.nodes = nil
+		s.rules[0].fastPath = nil
+		run(b, s)
+	})
+	b.Run("index", func(b *testing.B) {
+		s := newTestSyncer(b, expression, nodeNames, alerts)
+		s.rules[0].fastPath = nil
+		run(b, s)
+	})
+	b.Run("index_fast_path", func(b *testing.B) {
+		run(b, newTestSyncer(b, expression, nodeNames, alerts))
+	})
+}
@@ -0,0 +1,93 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Rule matches alerts to the nodes they concern. Each rule is matched
+// independently, so an alert may be matched to a node by several rules.
+type Rule struct {
+	// Name identifies the rule in Cache.Get
+	Name string
+	// Expression is the CEL expression run against each alert and node
+	Expression string
+	// NodeSelector limits the nodes the rule applies to. Nodes it does not
+	// select never match any alert. A nil selector selects every node.
+	NodeSelector labels.Selector
+}
+
+// compiledRule is a Rule ready for evaluation
+type compiledRule struct {
+	name     string
+	program  cel.Program
+	fastPath *labelEquality
+	usesNode bool
+	selector labels.Selector
+}
+
+func newEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Declarations(
+			decls.NewVar(labelsVar, decls.NewMapType(decls.String, decls.String)),
+			decls.NewVar(fullNameVar, decls.String),
+			decls.NewVar(shortNameVar, decls.String),
+			decls.NewVar(nodeVar, decls.NewMapType(decls.String, decls.Dyn)),
+			decls.NewVar(annotationsVar, decls.NewMapType(decls.String, decls.String)),
+			decls.NewVar(stateVar, decls.String),
+			decls.NewVar(activeAtVar, decls.Timestamp),
+			decls.NewVar(valueVar, decls.Double),
+			decls.NewVar(fingerprintVar, decls.String),
+			decls.NewVar(statusVar, decls.String),
+			decls.NewVar(nowVar, decls.Timestamp),
+		),
+		cel.Lib(&library{}),
+	)
+}
+
+func compileRules(rules []Rule) ([]*compiledRule, error) {
+	if len(rules) == 0 {
+		return nil, errors.New("at least one rule is required")
+	}
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+	compiled := make([]*compiledRule, 0, len(rules))
+	seen := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, errors.New("rule name must not be empty")
+		}
+		if _, dup := seen[rule.Name]; dup {
+			return nil, fmt.Errorf("duplicate rule %q", rule.Name)
+		}
+		seen[rule.Name] = struct{}{}
+
+		ast, issues := env.Compile(rule.Expression)
+		if err := issues.Err(); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		compiled = append(compiled, &compiledRule{
+			name:     rule.Name,
+			program:  program,
+			fastPath: parseLabelEquality(ast.NativeRep().Expr()),
+			usesNode: referencesIdent(ast.NativeRep().Expr(), nodeVar),
+			selector: rule.NodeSelector,
+		})
+	}
+	return compiled, nil
+}
+
+// selects reports whether the rule applies to the node
+func (r *compiledRule) selects(node *nodeView) bool {
+	return r.selector == nil || r.selector.Matches(node.labels)
+}
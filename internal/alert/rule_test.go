@@ -0,0 +1,113 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func Test_compileRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			rules: []Rule{{Name: "hardware", Expression: `true`}, {Name: "kernel", Expression: `false`}},
+		},
+		{
+			name:    "no rules",
+			wantErr: "at least one rule is required",
+		},
+		{
+			name:    "no name",
+			rules:   []Rule{{Expression: `true`}},
+			wantErr: "rule name must not be empty",
+		},
+		{
+			name:    "duplicate",
+			rules:   []Rule{{Name: "hardware", Expression: `true`}, {Name: "hardware", Expression: `false`}},
+			wantErr: `duplicate rule "hardware"`,
+		},
+		{
+			name:    "invalid expression",
+			rules:   []Rule{{Name: "hardware", Expression: `labels[`}},
+			wantErr: `rule "hardware": ERROR: <input>:1:8: Syntax error`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileRules(tt.rules)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_syncer_Get_rules(t *testing.T) {
+	gpuNode := &corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "node1", Labels: map[string]string{"accelerator": "gpu"}}}
+	plainNode := &corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "node2"}}
+	hardware := Alert{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "GPUOnFire", "team": "hardware"}}}
+	kernel := Alert{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "KernelPanic", "team": "kernel"}}}
+
+	selector, err := labels.Parse("accelerator=gpu")
+	assert.NoError(t, err)
+	rules := []Rule{
+		{Name: "hardware", Expression: `labels["team"] == "hardware"`, NodeSelector: selector},
+		{Name: "kernel", Expression: `labels["team"] == "kernel"`},
+	}
+	mClient := &mockAlertClient{}
+	mClient.On("GetAlerts", mock.Anything).Return([]Alert{hardware, kernel}, false, nil).Once()
+	events := make(chan event.TypedGenericEvent[*corev1.Node], 10)
+	s, err := NewSyncer(mClient, logr.Discard(), prometheus.NewRegistry(), rules, SyncerOptions{SyncInterval: time.Minute, FetchTimeout: time.Minute, Nodes: fake.NewClientBuilder().WithObjects(gpuNode, plainNode).Build(), Events: events})
+	assert.NoError(t, err)
+	s.SyncOnce()
+
+	tests := []struct {
+		node *corev1.Node
+		rule string
+		want []Alert
+	}{
+		{node: gpuNode, rule: "hardware", want: []Alert{hardware}},
+		{node: gpuNode, rule: "kernel", want: []Alert{kernel}},
+		{node: plainNode, rule: "hardware", want: []Alert{}},
+		{node: plainNode, rule: "kernel", want: []Alert{kernel}},
+	}
+	for _, tt := range tests {
+		alerts, _, err := s.Get(tt.node, tt.rule)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, append([]Alert{}, alerts...), tt.node.Name+"/"+tt.rule)
+	}
+
+	// a node that gained the label since the index was built is selected
+	relabeled := plainNode.DeepCopy()
+	relabeled.Labels = map[string]string{"accelerator": "gpu"}
+	alerts, _, err := s.Get(relabeled, "hardware")
+	assert.NoError(t, err)
+	assert.Equal(t, []Alert{hardware}, alerts)
+
+	_, _, err = s.Get(gpuNode, "network")
+	assert.EqualError(t, err, `unknown rule "network"`)
+
+	// a change matched by a single rule enqueues the node
+	mClient.On("GetAlerts", mock.Anything).Return([]Alert{kernel}, false, nil).Once()
+	s.SyncOnce()
+	assert.Equal(t, "node1", (<-events).Object.Name)
+	assert.Empty(t, events)
+	mClient.AssertExpectations(t)
+}
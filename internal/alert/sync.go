@@ -5,13 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"reflect"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/google/cel-go/cel"
-	"github.com/google/cel-go/checker/decls"
 	"github.com/google/cel-go/common/types"
 	"github.com/prometheus/alertmanager/api/v2/client"
 	"github.com/prometheus/alertmanager/api/v2/client/alert"
@@ -21,7 +22,12 @@ import (
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
@@ -33,15 +39,26 @@ type Syncer interface {
 	Cache
 	// SyncOnce enables the cache to be initialized before use by the Manager
 	SyncOnce()
+	// Push merges alerts received outside of the sync interval (e.g. from an
+	// Alertmanager webhook) into the cache. Firing alerts are added or replaced
+	// and resolved alerts are removed. Nodes matching any of the pushed alerts
+	// are enqueued for reconciliation.
+	Push(firing, resolved []Alert)
 }
 
 // Cache outlines an interface to interact with cached alerts
 type Cache interface {
-	// Get will return the currently cached alerts for a given node. An error
-	// will be returned if the cache is not populated, node specific filters
-	// cannot be run, or if the last retrieval resulted in an error. The time
-	// returned is the time of the last retrieval attempt.
-	Get(nodeName string) ([]promv1.Alert, time.Time, error)
+	// Get will return the currently cached alerts matched to a given node by the
+	// named rule. An error will be returned if the cache is not populated, the rule
+	// does not exist, or if the last retrieval resulted in an error. The time returned
+	// is the time of the last retrieval attempt. If the rule cannot be evaluated for
+	// some alerts, the alerts that matched are returned along with an *EvaluationError
+	// listing the others.
+	Get(node *corev1.Node, rule string) ([]Alert, time.Time, error)
+	// SyncedAt returns the time of the sync the cached alerts are from. Unlike the
+	// time returned by Get, pushed alerts do not advance it, so it tells syncs apart.
+	// It is zero while the cache holds an error.
+	SyncedAt() time.Time
 }
 
 type syncer struct {
@@ -49,41 +66,101 @@ type syncer struct {
 	cacheNumAlerts    prometheus.Gauge
 	alertsGetDuration prometheus.Histogram
 	alertsGetFailures prometheus.Counter
-	sync.RWMutex
-	program     cel.Program
-	alertClient Client
-	interval    time.Duration
-	results     []promv1.Alert
+	enqueuedNodes     prometheus.Counter
+	suspiciousDrops   prometheus.Counter
+	rules             []*compiledRule
+	ruleIndex         map[string]int
+	// usesNode is set when any rule refers to the node, in which case the
+	// node is projected into every activation
+	usesNode     bool
+	alertClient  Client
+	nodes        ctrlclient.Reader
+	events       chan<- event.TypedGenericEvent[*corev1.Node]
+	interval     time.Duration
+	fetchTimeout time.Duration
+	staleness    time.Duration
+	// watchdog is the name of an always firing alert, which a sync must return
+	watchdog      string
+	dropThreshold int
+	// writeMu serializes replacing the snapshot. Readers never take it, they
+	// load whichever snapshot was last stored.
+	writeMu  sync.Mutex
+	snapshot atomic.Pointer[snapshot]
+}
+
+// snapshot is the immutable result of a sync, amended by pushes through
+// copy-on-write
+type snapshot struct {
+	results     []Alert
 	retrievedAt time.Time
 	lastErr     error
+	// syncedAt is the time of the sync the results are from, which pushes
+	// carry over
+	syncedAt time.Time
+	// nodes and indexes are nil when nodes could not be listed
+	nodes *nodeSet
+	// indexes holds an index for each rule, in the order of syncer.rules
+	indexes []index
 }
 
-// NewSyncer provides an implementation of Syncer that gets alerts at syncInterval
+// SyncerOptions configures a Syncer returned by NewSyncer
+type SyncerOptions struct {
+	// SyncInterval is how often alerts are fetched
+	SyncInterval time.Duration
+	// FetchTimeout bounds each fetch
+	FetchTimeout time.Duration
+	// Staleness is how long the last results are served after a failed fetch
+	Staleness time.Duration
+	// Watchdog names an always firing alert, if any
+	Watchdog string
+	// DropThreshold is the number of alerts a sync must have returned for an empty
+	// one after it to be suspicious
+	DropThreshold int
+	// Nodes lists the nodes matched against alerts up front
+	Nodes ctrlclient.Reader
+	// Events receives the nodes whose alerts changed
+	Events chan<- event.TypedGenericEvent[*corev1.Node]
+}
+
+// NewSyncer provides an implementation of Syncer that gets alerts every SyncInterval,
+// giving up on each fetch after FetchTimeout. Fetches happen without blocking Get,
+// which keeps serving the previous results until the fetch completes. The alerts
+// of a node are matched separately for each of rules.
+//
+// After each sync the nodes listed through Nodes are matched against the alerts
+// up front, so Get is a lookup rather than an evaluation per alert. Expressions that
+// only compare labels to FullName or ShortName skip CEL evaluation entirely.
+//
+// Nodes whose matched alerts changed since the previous sync, or that are affected
+// by pushed alerts, are sent to Events so that they can be reconciled without
+// waiting for a resync. Sending never blocks: if Events is full the node is dropped
+// and left to the next resync. Either may be nil, which disables the index and
+// enqueuing respectively.
+//
+// A failed fetch keeps serving the results of the last successful sync until they are
+// older than Staleness, so that a short outage of the alert source does not make every
+// condition Unknown. A zero Staleness serves the error straight away.
+//
+// An empty response cannot be told apart from every node being healthy. When Watchdog
+// names an always firing alert, a sync that does not return it fails. A sync returning
+// no alerts after one that returned at least DropThreshold is logged and counted as
+// suspicious; a zero DropThreshold disables this.
 func NewSyncer(
 	alertClient Client,
 	log logr.Logger,
 	prom prometheus.Registerer,
-	celExpression string,
-	syncInterval time.Duration,
+	rules []Rule,
+	opts SyncerOptions,
 ) (Syncer, error) {
-	env, err := cel.NewEnv(
-		cel.Declarations(
-			decls.NewVar("labels", decls.NewMapType(decls.String, decls.String)),
-			decls.NewVar("FullName", decls.String),
-			decls.NewVar("ShortName", decls.String),
-		),
-	)
+	compiled, err := compileRules(rules)
 	if err != nil {
 		return nil, err
 	}
-
-	ast, issues := env.Compile(celExpression)
-	if err := issues.Err(); err != nil {
-		return nil, err
-	}
-	program, err := env.Program(ast)
-	if err != nil {
-		return nil, err
+	ruleIndex := make(map[string]int, len(compiled))
+	usesNode := false
+	for i, rule := range compiled {
+		ruleIndex[rule.name] = i
+		usesNode = usesNode || rule.usesNode
 	}
 
 	cacheNumAlerts := prometheus.NewGauge(prometheus.GaugeOpts{
@@ -105,10 +182,24 @@ func NewSyncer(
 		Help:      "Count of alerts get failures",
 	})
 
+	enqueuedNodes := prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: "sync",
+		Name:      "enqueued_nodes",
+		Help:      "Count of nodes enqueued because their alerts changed",
+	})
+
+	suspiciousDrops := prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: "sync",
+		Name:      "suspicious_drops",
+		Help:      "Count of syncs returning no alerts after a sync returned many",
+	})
+
 	prom.MustRegister(
 		cacheNumAlerts,
 		alertsGetDuration,
 		alertsGetFailures,
+		enqueuedNodes,
+		suspiciousDrops,
 	)
 
 	return &syncer{
@@ -116,14 +207,24 @@ func NewSyncer(
 		log:               log,
 		alertsGetDuration: alertsGetDuration,
 		alertsGetFailures: alertsGetFailures,
-		program:           program,
+		enqueuedNodes:     enqueuedNodes,
+		suspiciousDrops:   suspiciousDrops,
+		rules:             compiled,
+		ruleIndex:         ruleIndex,
+		usesNode:          usesNode,
 		alertClient:       alertClient,
-		interval:          syncInterval,
+		nodes:             opts.Nodes,
+		events:            opts.Events,
+		interval:          opts.SyncInterval,
+		fetchTimeout:      opts.FetchTimeout,
+		staleness:         opts.Staleness,
+		watchdog:          opts.Watchdog,
+		dropThreshold:     opts.DropThreshold,
 	}, nil
 }
 
 type Client interface {
-	GetAlerts(context.Context) ([]promv1.Alert, bool, error)
+	GetAlerts(context.Context) ([]Alert, bool, error)
 }
 
 func (s *syncer) NeedLeaderElection() bool {
@@ -135,58 +236,318 @@ func (s *syncer) Start(ctx context.Context) error {
 	return nil
 }
 
-func (s *syncer) Get(nodeName string) ([]promv1.Alert, time.Time, error) {
-	s.RLock()
-	defer s.RUnlock()
-	if s.retrievedAt.IsZero() {
-		return nil, s.retrievedAt, errors.New("cache is not yet ready")
+func (s *syncer) SyncedAt() time.Time {
+	snap := s.snapshot.Load()
+	if snap == nil {
+		return time.Time{}
 	}
+	return snap.syncedAt
+}
 
-	if s.lastErr != nil {
-		return nil, s.retrievedAt, s.lastErr
+func (s *syncer) Get(node *corev1.Node, ruleName string) ([]Alert, time.Time, error) {
+	i, ok := s.ruleIndex[ruleName]
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("unknown rule %q", ruleName)
 	}
+	rule := s.rules[i]
 
-	matchedAlerts := make([]promv1.Alert, 0, 1)
-	for _, al := range s.results {
-		out, _, err := s.program.Eval(map[string]any{
-			"labels":    al.Labels,
-			"FullName":  nodeName,
-			"ShortName": strings.Split(nodeName, ".")[0],
-		})
+	snap := s.snapshot.Load()
+	if snap == nil {
+		return nil, time.Time{}, errors.New("cache is not yet ready")
+	}
+
+	if snap.lastErr != nil {
+		return nil, snap.retrievedAt, snap.lastErr
+	}
+
+	view := newNodeView(node, s.usesNode)
+	var matched *nodeAlerts
+	indexed := false
+	if snap.indexes != nil {
+		matched, indexed = snap.indexes[i][node.Name]
+	}
+	if !indexed || ((rule.usesNode || rule.selector != nil) && !reflect.DeepEqual(view, snap.nodes.views[node.Name])) {
+		// the node joined or changed after the index was built
+		matched = s.match(rule, snap.results, view, snap.retrievedAt)
+	}
+	if len(matched.failed) > 0 {
+		evalErr := &EvaluationError{Failures: make([]AlertError, 0, len(matched.failed))}
+		for _, fm := range matched.failed {
+			evalErr.Failures = append(evalErr.Failures, AlertError{Alert: fm.alert, Err: fm.err})
+		}
+		return matched.alerts, snap.retrievedAt, evalErr
+	}
+	return matched.alerts, snap.retrievedAt, nil
+}
+
+// match evaluates alerts against a single node
+func (s *syncer) match(rule *compiledRule, alerts []Alert, node *nodeView, now time.Time) *nodeAlerts {
+	matched := &nodeAlerts{alerts: make([]Alert, 0, 1)}
+	if !rule.selects(node) {
+		return matched
+	}
+	for _, al := range alerts {
+		ok, err := s.matches(rule, al, node, now)
 		if err != nil {
-			return nil, s.retrievedAt, fmt.Errorf("cel evaluation error: %w", err)
+			matched.failed = append(matched.failed, failedMatch{alert: al, err: err})
+		} else if ok {
+			matched.alerts = append(matched.alerts, al)
 		}
-		if out == types.True {
-			matchedAlerts = append(matchedAlerts, al)
+	}
+	return matched
+}
+
+func (s *syncer) matches(rule *compiledRule, al Alert, node *nodeView, now time.Time) (bool, error) {
+	if rule.fastPath != nil {
+		if matched, ok := rule.fastPath.matches(al, node.name); ok {
+			return matched, nil
 		}
 	}
-	return matchedAlerts, s.retrievedAt, s.lastErr
+	out, _, err := rule.program.Eval(node.activation(al, now))
+	if err != nil {
+		return false, fmt.Errorf("cel evaluation error: %w", err)
+	}
+	return out == types.True, nil
 }
 
-func (s *syncer) SyncOnce() {
-	s.Lock()
-	defer s.Unlock()
+// buildIndexes matches alerts against every node in ns for each rule
+func (s *syncer) buildIndexes(alerts []Alert, ns *nodeSet, now time.Time) []index {
+	indexes := make([]index, 0, len(s.rules))
+	for _, rule := range s.rules {
+		idx := make(index, len(ns.names))
+		for _, name := range ns.names {
+			idx[name] = &nodeAlerts{}
+		}
+		s.indexAlerts(rule, idx, alerts, ns, now)
+		indexes = append(indexes, idx)
+	}
+	return indexes
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+func (s *syncer) indexAlerts(rule *compiledRule, idx index, alerts []Alert, ns *nodeSet, now time.Time) {
+	for _, al := range alerts {
+		if rule.fastPath != nil {
+			if names, ok := rule.fastPath.nodes(al, ns); ok {
+				for _, name := range names {
+					if rule.selects(ns.views[name]) {
+						idx.add(name, al)
+					}
+				}
+				continue
+			}
+		}
+		for _, name := range ns.names {
+			if !rule.selects(ns.views[name]) {
+				continue
+			}
+			matched, err := s.matches(rule, al, ns.views[name], now)
+			if err != nil {
+				idx.fail(name, al, err)
+			} else if matched {
+				idx.add(name, al)
+			}
+		}
+	}
+}
+
+func (s *syncer) listNodes() *nodeSet {
+	if s.nodes == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.fetchTimeout)
+	defer cancel()
+	nodes := &corev1.NodeList{}
+	if err := s.nodes.List(ctx, nodes); err != nil {
+		// the initial sync happens before the manager starts the cache
+		var notStarted *cache.ErrCacheNotStarted
+		if !errors.As(err, &notStarted) {
+			s.log.Error(err, "could not list nodes to index alerts")
+		}
+		return nil
+	}
+	return newNodeSet(nodes.Items, s.usesNode)
+}
+
+func (s *syncer) Push(firing, resolved []Alert) {
+	s.writeMu.Lock()
+	previous := s.snapshot.Load()
+	// pushed alerts only amend a healthy cache, otherwise they would mask
+	// the sync error or present a partial view as complete
+	if previous == nil || previous.lastErr != nil {
+		s.writeMu.Unlock()
+		s.log.Info("cache is not ready, ignoring pushed alerts", "firing", len(firing), "resolved", len(resolved))
+		return
+	}
+	pushed := make(map[model.Fingerprint]struct{}, len(firing)+len(resolved))
+	for _, al := range resolved {
+		pushed[al.Labels.Fingerprint()] = struct{}{}
+	}
+	for _, al := range firing {
+		pushed[al.Labels.Fingerprint()] = struct{}{}
+	}
+	notPushed := func(al Alert) bool {
+		_, ok := pushed[al.Labels.Fingerprint()]
+		return !ok
+	}
+	current := &snapshot{
+		results:     append(filterAlerts(previous.results, notPushed), firing...),
+		retrievedAt: time.Now(),
+		syncedAt:    previous.syncedAt,
+		nodes:       previous.nodes,
+	}
+	if previous.indexes != nil {
+		// only the pushed alerts need to be matched again
+		current.indexes = make([]index, 0, len(previous.indexes))
+		for i, previousIndex := range previous.indexes {
+			idx := make(index, len(previousIndex))
+			for name, matched := range previousIndex {
+				failed := make([]failedMatch, 0, len(matched.failed))
+				for _, fm := range matched.failed {
+					if notPushed(fm.alert) {
+						failed = append(failed, fm)
+					}
+				}
+				idx[name] = &nodeAlerts{
+					alerts: filterAlerts(matched.alerts, notPushed),
+					failed: failed,
+				}
+			}
+			s.indexAlerts(s.rules[i], idx, firing, current.nodes, current.retrievedAt)
+			current.indexes = append(current.indexes, idx)
+		}
+	}
+	s.snapshot.Store(current)
+	s.cacheNumAlerts.Set(float64(len(current.results)))
+	s.writeMu.Unlock()
+
+	s.enqueue(changedNodes(previous, current))
+}
+
+func filterAlerts(alerts []Alert, keep func(Alert) bool) []Alert {
+	filtered := make([]Alert, 0, len(alerts))
+	for _, al := range alerts {
+		if keep(al) {
+			filtered = append(filtered, al)
+		}
+	}
+	return filtered
+}
+
+// enqueue sends the named nodes to the events channel
+func (s *syncer) enqueue(nodeNames []string) {
+	if s.events == nil {
+		return
+	}
+	for _, name := range nodeNames {
+		select {
+		case s.events <- event.TypedGenericEvent[*corev1.Node]{Object: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}}:
+			s.enqueuedNodes.Inc()
+		default:
+			s.log.V(1).Info("event channel is full, leaving node to resync", "node", name)
+		}
+	}
+}
+
+func (s *syncer) SyncOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.fetchTimeout)
 	defer cancel()
 
 	timer := prometheus.NewTimer(s.alertsGetDuration)
-	defer timer.ObserveDuration()
-	var resp []promv1.Alert
-	var partial bool
-	resp, partial, s.lastErr = s.alertClient.GetAlerts(ctx)
-	s.retrievedAt = time.Now()
-	if s.lastErr == nil {
-		s.results = resp
-		s.cacheNumAlerts.Set(float64(len(s.results)))
-	} else {
-		s.results = nil
+	resp, partial, err := s.alertClient.GetAlerts(ctx)
+	timer.ObserveDuration()
+	if err == nil {
+		resp, err = s.validate(resp)
 	}
 	// surface sync errors
-	if partial || s.lastErr != nil {
-		s.log.Error(s.lastErr, "could not retrieve all alerts")
+	if partial || err != nil {
+		s.log.Error(err, "could not retrieve all alerts")
 		s.alertsGetFailures.Inc()
 	}
+	if last := s.snapshot.Load(); err != nil && last != nil && last.lastErr == nil && time.Since(last.syncedAt) < s.staleness {
+		s.log.Info("serving alerts of the last successful sync", "syncedAt", last.syncedAt)
+		return
+	}
+
+	current := &snapshot{
+		retrievedAt: time.Now(),
+		lastErr:     err,
+		nodes:       s.listNodes(),
+	}
+	if err == nil {
+		current.syncedAt = current.retrievedAt
+		current.results = resp
+		s.cacheNumAlerts.Set(float64(len(resp)))
+		if current.nodes != nil {
+			current.indexes = s.buildIndexes(resp, current.nodes, current.retrievedAt)
+		}
+	}
+
+	s.writeMu.Lock()
+	previous := s.snapshot.Swap(current)
+	s.writeMu.Unlock()
+
+	// every node is reconciled on startup, so only later syncs need to enqueue
+	if previous != nil {
+		s.enqueue(changedNodes(previous, current))
+	}
+}
+
+// validate returns an error if alerts are missing the watchdog alert, and flags a
+// suspicious drop to no alerts. The watchdog alert is not about any node, so it is
+// removed from the returned alerts.
+func (s *syncer) validate(alerts []Alert) ([]Alert, error) {
+	if s.watchdog != "" {
+		isWatchdog := func(al Alert) bool {
+			return string(al.Labels[model.AlertNameLabel]) == s.watchdog
+		}
+		if !slices.ContainsFunc(alerts, isWatchdog) {
+			return nil, fmt.Errorf("watchdog alert %q is missing", s.watchdog)
+		}
+		alerts = slices.DeleteFunc(alerts, isWatchdog)
+	}
+	if last := s.snapshot.Load(); s.dropThreshold > 0 && len(alerts) == 0 &&
+		last != nil && last.lastErr == nil && len(last.results) >= s.dropThreshold {
+		s.log.Info("alerts suspiciously dropped to none", "previous", len(last.results))
+		s.suspiciousDrops.Inc()
+	}
+	return alerts, nil
+}
+
+// changedNodes returns the nodes of current whose matched alerts differ from previous
+func changedNodes(previous, current *snapshot) []string {
+	if current.nodes == nil {
+		return nil
+	}
+	if (previous.lastErr == nil) != (current.lastErr == nil) {
+		// all owned conditions move to or from Unknown
+		return current.nodes.names
+	}
+	if current.indexes == nil {
+		return nil
+	}
+	changed := make([]string, 0)
+	for _, name := range current.nodes.names {
+		for i, idx := range current.indexes {
+			var old *nodeAlerts
+			if previous.indexes != nil {
+				old = previous.indexes[i][name]
+			}
+			if old == nil {
+				old = &nodeAlerts{}
+			}
+			if !sameAlerts(old, idx[name]) {
+				changed = append(changed, name)
+				break
+			}
+		}
+	}
+	return changed
+}
+
+func sameAlerts(a, b *nodeAlerts) bool {
+	return len(a.alerts) == len(b.alerts) && len(a.failed) == len(b.failed) &&
+		(len(a.alerts) == 0 || reflect.DeepEqual(a.alerts, b.alerts)) &&
+		(len(a.failed) == 0 || reflect.DeepEqual(a.failed, b.failed))
 }
 
 // Get alerts from a single prometheus
@@ -203,32 +564,31 @@ func NewPrometheusClient(address string) (Client, error) {
 	}, err
 }
 
-func (p *PromClient) GetAlerts(ctx context.Context) ([]promv1.Alert, bool, error) {
+func (p *PromClient) GetAlerts(ctx context.Context) ([]Alert, bool, error) {
 	partial := false // does not apply to a single prometheus
 	alerts, err := p.api.Alerts(ctx)
 	if err != nil {
 		return nil, partial, err
 	}
-	filteredAlerts := make([]promv1.Alert, 0)
+	filteredAlerts := make([]Alert, 0)
 	for _, alert := range alerts.Alerts {
 		// api/v1/alerts does not accept query parameters
 		// filter out alerts that are not firing (e.g. pending)
 		if alert.State != promv1.AlertStateFiring {
 			continue
 		}
-		filteredAlerts = append(filteredAlerts, alert)
+		filteredAlerts = append(filteredAlerts, Alert{Alert: alert})
 	}
 	return filteredAlerts, partial, nil
 }
 
-// Get alerts from Alertmanager
+// Get alerts from Alertmanager, including those that are silenced or inhibited
 type AlertmanagerClient struct {
 	client   *client.AlertmanagerAPI
 	receiver string
-	silenced bool
 }
 
-func NewAlertmanagerClient(address, receiver string, silenced bool) (*AlertmanagerClient, error) {
+func NewAlertmanagerClient(address, receiver string) (*AlertmanagerClient, error) {
 	parsedURL, err := url.Parse(address)
 	if err != nil {
 		return nil, err
@@ -237,86 +597,276 @@ func NewAlertmanagerClient(address, receiver string, silenced bool) (*Alertmanag
 	return &AlertmanagerClient{
 		client:   cli.NewAlertmanagerClient(parsedURL),
 		receiver: receiver,
-		silenced: silenced,
 	}, nil
 }
 
 // Get alerts from multiple promethei and combine them
 type PromMultiClient struct {
-	clients []PromClient
+	clients []Client
+	// sources are the labels of clients in metrics and logs
+	sources []string
+	timeout time.Duration
+	quorum  int
+	log     logr.Logger
+
+	sourceGets        *prometheus.CounterVec
+	sourceGetDuration *prometheus.HistogramVec
 }
 
-func NewPrometheusMultiClient(addresses []string) (Client, error) {
-	clients := make([]PromClient, 0, len(addresses))
-	for _, address := range addresses {
+// NewPrometheusMultiClient gets alerts from all addresses concurrently, giving up on
+// each after sourceTimeout so that a slow source does not hold up the others. A zero
+// sourceTimeout leaves only the timeout of the whole fetch. The alerts are valid once
+// at least quorum sources answered, with failures of the others reported as a partial
+// result.
+func NewPrometheusMultiClient(
+	addresses []string,
+	log logr.Logger,
+	prom prometheus.Registerer,
+	sourceTimeout time.Duration,
+	quorum int,
+) (Client, error) {
+	clients := make([]Client, 0, len(addresses))
+	sources := make([]string, 0, len(addresses))
+	for i, address := range addresses {
 		c, err := api.NewClient(api.Config{
 			Address: address,
 		})
 		if err != nil {
 			return nil, err
 		}
-		clients = append(clients, PromClient{
+		clients = append(clients, &PromClient{
 			api: promv1.NewAPI(c)},
 		)
+		sources = append(sources, sourceLabel(i, address))
 	}
+	return newPromMultiClient(clients, sources, log, prom, sourceTimeout, quorum)
+}
 
-	return &PromMultiClient{clients: clients}, nil
+func newPromMultiClient(
+	clients []Client,
+	sources []string,
+	log logr.Logger,
+	prom prometheus.Registerer,
+	sourceTimeout time.Duration,
+	quorum int,
+) (*PromMultiClient, error) {
+	if quorum < 1 || quorum > len(clients) {
+		return nil, fmt.Errorf("quorum %d must be between 1 and the %d sources", quorum, len(clients))
+	}
+
+	sourceGets := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "sync",
+		Name:      "source_gets",
+		Help:      "Count of alerts gets from each Prometheus source by result",
+	}, []string{"source", "result"})
+
+	sourceGetDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "sync",
+		Name:      "source_get_duration",
+		Help:      "Time to get alerts from each Prometheus source",
+		Buckets:   []float64{0.5, 1, 2, 3, 4, 5, 10, 15, 30, 60, 120},
+	}, []string{"source"})
+
+	prom.MustRegister(sourceGets, sourceGetDuration)
+
+	return &PromMultiClient{
+		clients:           clients,
+		sources:           sources,
+		timeout:           sourceTimeout,
+		quorum:            quorum,
+		log:               log,
+		sourceGets:        sourceGets,
+		sourceGetDuration: sourceGetDuration,
+	}, nil
 }
 
-func (p *PromMultiClient) GetAlerts(ctx context.Context) ([]promv1.Alert, bool, error) {
-	// Get alerts for each prometheus client
-	allAlerts := make([]promv1.Alert, 0)
-	var allErrs error
-	failures := 0
+const (
+	sourceGetSuccess = "success"
+	sourceGetFailure = "failure"
+)
 
-	for _, client := range p.clients {
-		alerts, _, err := client.GetAlerts(ctx)
-		if err != nil {
-			allErrs = errors.Join(allErrs, err)
+// sourceLabel names the source at address in metrics and logs by its host and path,
+// leaving out credentials and query parameters. Addresses that cannot be parsed are
+// named by their position.
+func sourceLabel(i int, address string) string {
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.Host == "" {
+		return fmt.Sprintf("source%d", i)
+	}
+	return parsed.Host + strings.TrimSuffix(parsed.Path, "/")
+}
+
+func (p *PromMultiClient) GetAlerts(ctx context.Context) ([]Alert, bool, error) {
+	// Get alerts for each prometheus client concurrently
+	results := make([][]Alert, len(p.clients))
+	errs := make([]error, len(p.clients))
+	var wg sync.WaitGroup
+	for i, client := range p.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = p.getSource(ctx, i, client)
+		}()
+	}
+	wg.Wait()
+
+	allAlerts := make([]Alert, 0)
+	failures := 0
+	for i, alerts := range results {
+		if errs[i] != nil {
 			failures++
-		} else {
-			allAlerts = append(allAlerts, alerts...)
+			continue
 		}
+		allAlerts = append(allAlerts, alerts...)
 	}
 
-	// a partial result is when some, but not all
-	// of the clients returned errors
-	partial := failures > 0 && failures != len(p.clients)
+	if successes := len(p.clients) - failures; successes < p.quorum {
+		return nil, false, fmt.Errorf("%d of %d sources answered, below the quorum of %d: %w",
+			successes, len(p.clients), p.quorum, errors.Join(errs...))
+	}
+
+	// a partial result is when some of the clients returned errors,
+	// but the quorum answered
+	partial := failures > 0
 
 	// do not return errors on partial failures
 	// metrics will surface the issue
 	// and conditions will still reconcile
-	if partial {
-		return allAlerts, partial, nil
+	return allAlerts, partial, nil
+}
+
+// getSource gets the alerts of the i-th client within the per-source timeout
+func (p *PromMultiClient) getSource(ctx context.Context, i int, client Client) ([]Alert, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	source := p.sources[i]
+	start := time.Now()
+	alerts, _, err := client.GetAlerts(ctx)
+	p.sourceGetDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+	if err != nil {
+		p.sourceGets.WithLabelValues(source, sourceGetFailure).Inc()
+		p.log.Error(err, "unable to get alerts from source", "source", source)
+		return nil, fmt.Errorf("%s: %w", source, err)
 	}
-	return allAlerts, partial, allErrs
+	p.sourceGets.WithLabelValues(source, sourceGetSuccess).Inc()
+	return alerts, nil
 }
 
-func (a *AlertmanagerClient) GetAlerts(ctx context.Context) ([]promv1.Alert, bool, error) {
+func (a *AlertmanagerClient) GetAlerts(ctx context.Context) ([]Alert, bool, error) {
 	active := true
+	// suppressed alerts are kept apart by their status
+	suppressed := true
 	partial := false
 	alerts, err := a.client.Alert.GetAlerts(&alert.GetAlertsParams{
-		Silenced: &a.silenced,
-		Active:   &active,
-		Receiver: &a.receiver,
-		Context:  ctx,
+		Silenced:  &suppressed,
+		Inhibited: &suppressed,
+		Active:    &active,
+		Receiver:  &a.receiver,
+		Context:   ctx,
 	})
 	if err != nil {
 		return nil, partial, err
 	}
 
 	// convert AlertManager alerts into the prometheus alert structure
-	filteredAlerts := make([]promv1.Alert, 0, len(alerts.Payload))
+	filteredAlerts := make([]Alert, 0, len(alerts.Payload))
 	for _, alert := range alerts.Payload {
-		filteredAlerts = append(filteredAlerts, promv1.Alert{
-			Annotations: convertToLabelSet(alert.Annotations),
-			Labels:      convertToLabelSet(alert.Labels),
-			State:       promv1.AlertStateFiring,
-		})
+		filteredAlerts = append(filteredAlerts, convertGettableAlert(alert))
 	}
 	return filteredAlerts, partial, nil
 }
 
+// Get alerts from the peers of an Alertmanager cluster and combine them
+type AlertmanagerMultiClient struct {
+	peers []Client
+}
+
+func NewAlertmanagerMultiClient(addresses []string, receiver string) (Client, error) {
+	peers := make([]Client, 0, len(addresses))
+	for _, address := range addresses {
+		c, err := NewAlertmanagerClient(address, receiver)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, c)
+	}
+	return &AlertmanagerMultiClient{peers: peers}, nil
+}
+
+// GetAlerts queries all peers concurrently. Peers of a cluster share their alerts, so
+// the alerts are deduplicated by fingerprint, preferring those of earlier peers. The
+// alerts are valid as long as one peer answers, with failures of the others reported
+// as a partial result.
+func (a *AlertmanagerMultiClient) GetAlerts(ctx context.Context) ([]Alert, bool, error) {
+	results := make([][]Alert, len(a.peers))
+	errs := make([]error, len(a.peers))
+	var wg sync.WaitGroup
+	for i, peer := range a.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _, errs[i] = peer.GetAlerts(ctx)
+		}()
+	}
+	wg.Wait()
+
+	allAlerts := make([]Alert, 0)
+	seen := make(map[string]bool)
+	failures := 0
+	for i, alerts := range results {
+		if errs[i] != nil {
+			failures++
+			continue
+		}
+		for _, al := range alerts {
+			if al.Fingerprint != "" {
+				if seen[al.Fingerprint] {
+					continue
+				}
+				seen[al.Fingerprint] = true
+			}
+			allAlerts = append(allAlerts, al)
+		}
+	}
+
+	partial := failures > 0 && failures != len(a.peers)
+	if failures == len(a.peers) {
+		return nil, partial, errors.Join(errs...)
+	}
+	return allAlerts, partial, nil
+}
+
+func convertGettableAlert(input *models.GettableAlert) Alert {
+	al := Alert{
+		Alert: promv1.Alert{
+			Annotations: convertToLabelSet(input.Annotations),
+			Labels:      convertToLabelSet(input.Labels),
+			State:       promv1.AlertStateFiring,
+		},
+	}
+	if input.StartsAt != nil {
+		al.ActiveAt = time.Time(*input.StartsAt)
+	}
+	if input.Fingerprint != nil {
+		al.Fingerprint = *input.Fingerprint
+	}
+	if input.Status != nil {
+		if input.Status.State != nil {
+			al.Status = *input.Status.State
+		}
+		if len(input.Status.SilencedBy) > 0 {
+			al.SilencedBy = input.Status.SilencedBy
+		}
+		if len(input.Status.InhibitedBy) > 0 {
+			al.InhibitedBy = input.Status.InhibitedBy
+		}
+	}
+	return al
+}
+
 func convertToLabelSet(input models.LabelSet) model.LabelSet {
 	res := make(model.LabelSet, len(input))
 	for k, v := range input {
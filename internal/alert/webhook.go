@@ -0,0 +1,134 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cloudflare/sciuro/internal/bearer"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	webhookVersion = "4"
+	statusFiring   = "firing"
+	statusResolved = "resolved"
+	// maxWebhookBodyBytes bounds the size of a single notification
+	maxWebhookBodyBytes = 10 << 20
+)
+
+// webhookMessage is the payload Alertmanager sends to a webhook receiver
+type webhookMessage struct {
+	Version  string         `json:"version"`
+	GroupKey string         `json:"groupKey"`
+	Status   string         `json:"status"`
+	Receiver string         `json:"receiver"`
+	Alerts   []webhookAlert `json:"alerts"`
+}
+
+type webhookAlert struct {
+	Status      string         `json:"status"`
+	Labels      model.LabelSet `json:"labels"`
+	Annotations model.LabelSet `json:"annotations"`
+	StartsAt    time.Time      `json:"startsAt"`
+	EndsAt      time.Time      `json:"endsAt"`
+	Fingerprint string         `json:"fingerprint"`
+}
+
+type webhookHandler struct {
+	log                  logr.Logger
+	syncer               Syncer
+	receiver             string
+	token                string
+	notificationsCounter *prometheus.CounterVec
+}
+
+// NewWebhookHandler returns an http.Handler that accepts Alertmanager webhook
+// notifications and pushes their alerts into the Syncer. If receiver is set,
+// notifications for any other receiver are rejected. If token is set, notifications
+// must carry it as a bearer token, as sent by the authorization of Alertmanager's
+// http_config.
+func NewWebhookHandler(s Syncer, log logr.Logger, prom prometheus.Registerer, receiver, token string) http.Handler {
+	notificationsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "webhook",
+		Name:      "notifications",
+		Help:      "Count of webhook notifications received",
+	}, []string{"code"})
+
+	prom.MustRegister(notificationsCounter)
+
+	return &webhookHandler{
+		log:                  log,
+		syncer:               s,
+		receiver:             receiver,
+		token:                token,
+		notificationsCounter: notificationsCounter,
+	}
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes)
+	code, err := h.handle(r)
+	h.notificationsCounter.WithLabelValues(fmt.Sprint(code)).Inc()
+	if err != nil {
+		h.log.Error(err, "could not handle webhook notification", "code", code)
+		if code == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.WriteHeader(code)
+}
+
+func (h *webhookHandler) handle(r *http.Request) (int, error) {
+	if !bearer.Authorized(r, h.token) {
+		return http.StatusUnauthorized, errors.New("missing or invalid bearer token")
+	}
+	if r.Method != http.MethodPost {
+		return http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)
+	}
+	msg := &webhookMessage{}
+	if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("malformed notification: %w", err)
+	}
+	if msg.Version != webhookVersion {
+		return http.StatusBadRequest, fmt.Errorf("unsupported notification version %q", msg.Version)
+	}
+	if h.receiver != "" && msg.Receiver != h.receiver {
+		return http.StatusBadRequest, fmt.Errorf("unexpected receiver %q", msg.Receiver)
+	}
+
+	firing := make([]Alert, 0, len(msg.Alerts))
+	resolved := make([]Alert, 0)
+	for _, wa := range msg.Alerts {
+		al := Alert{
+			Alert: promv1.Alert{
+				Annotations: wa.Annotations,
+				Labels:      wa.Labels,
+				State:       promv1.AlertStateFiring,
+				ActiveAt:    wa.StartsAt,
+			},
+			Fingerprint: wa.Fingerprint,
+			// notifications are only sent for alerts that are not suppressed
+			Status: models.AlertStatusStateActive,
+		}
+		switch wa.Status {
+		case statusFiring:
+			firing = append(firing, al)
+		case statusResolved:
+			resolved = append(resolved, al)
+		default:
+			return http.StatusBadRequest, fmt.Errorf("unknown alert status %q", wa.Status)
+		}
+	}
+	h.log.V(1).Info("received webhook notification", "groupKey", msg.GroupKey, "firing", len(firing), "resolved", len(resolved))
+	h.syncer.Push(firing, resolved)
+	return http.StatusOK, nil
+}
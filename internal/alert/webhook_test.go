@@ -0,0 +1,154 @@
+package alert
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	firingNotification = `{
+  "version": "4",
+  "groupKey": "{}:{alertname=\"NodeOnFire\"}",
+  "status": "firing",
+  "receiver": "node-condition-k8s",
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "NodeOnFire", "instance": "node2"},
+      "annotations": {"summary": "Node has erupted into fire at 500C"},
+      "startsAt": "2020-03-18T12:33:45Z",
+      "fingerprint": "c4b4f8c1e9d8e2b4"
+    }
+  ]
+}`
+	resolvedNotification = `{
+  "version": "4",
+  "status": "resolved",
+  "receiver": "node-condition-k8s",
+  "alerts": [
+    {
+      "status": "resolved",
+      "labels": {"alertname": "HouseOnFire", "instance": "node1"},
+      "startsAt": "2020-03-18T12:33:45Z",
+      "endsAt": "2020-03-18T13:17:58Z"
+    }
+  ]
+}`
+)
+
+func Test_webhookHandler(t *testing.T) {
+	mClient := &mockAlertClient{}
+	nodes := fake.NewClientBuilder().WithObjects(
+		&corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "node1"}},
+		&corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "node2"}},
+	).Build()
+	events := make(chan event.TypedGenericEvent[*corev1.Node], 10)
+	s, err := NewSyncer(mClient, logr.Discard(), prometheus.NewRegistry(), singleRule(`labels["instance"] == FullName`), SyncerOptions{SyncInterval: time.Minute, FetchTimeout: time.Minute, Nodes: nodes, Events: events})
+	assert.NoError(t, err)
+	h := NewWebhookHandler(s, logr.Discard(), prometheus.NewRegistry(), "node-condition-k8s", "")
+
+	// pushes are ignored until the cache has synced
+	assert.Equal(t, http.StatusOK, post(h, http.MethodPost, firingNotification))
+	_, _, err = s.Get(namedNode("node2"), testRule)
+	assert.EqualError(t, err, "cache is not yet ready")
+	assert.Empty(t, events)
+	assert.True(t, s.SyncedAt().IsZero())
+
+	mClient.On("GetAlerts", mock.Anything).Return(response1(), false, nil).Once()
+	s.SyncOnce()
+	mClient.AssertExpectations(t)
+	syncedAt := s.SyncedAt()
+	assert.False(t, syncedAt.IsZero())
+
+	// pushes do not advance the sync time
+	assert.Equal(t, http.StatusOK, post(h, http.MethodPost, firingNotification))
+	assert.Equal(t, syncedAt, s.SyncedAt())
+	alerts, _, err := s.Get(namedNode("node2"), testRule)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []Alert{
+		{
+			Alert: promv1.Alert{
+				State: promv1.AlertStateFiring,
+				Labels: model.LabelSet{
+					"alertname": "NodeOnFire",
+					"instance":  "node2",
+				},
+				Annotations: model.LabelSet{
+					"summary": "Node has erupted into fire at 500C",
+				},
+				ActiveAt: time.Date(2020, time.March, 18, 12, 33, 45, 0, time.UTC),
+			},
+			Fingerprint: "c4b4f8c1e9d8e2b4",
+			Status:      "active",
+		},
+	}, alerts)
+	assert.Equal(t, "node2", (<-events).Object.Name)
+	assert.Empty(t, events)
+
+	assert.Equal(t, http.StatusOK, post(h, http.MethodPost, resolvedNotification))
+	alerts, _, err = s.Get(namedNode("node1"), testRule)
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+	assert.Equal(t, "node1", (<-events).Object.Name)
+	assert.Empty(t, events)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, post(h, http.MethodGet, ""))
+	assert.Equal(t, http.StatusBadRequest, post(h, http.MethodPost, "{"))
+	assert.Equal(t, http.StatusBadRequest, post(h, http.MethodPost, strings.Replace(firingNotification, `"4"`, `"3"`, 1)))
+	assert.Equal(t, http.StatusBadRequest, post(h, http.MethodPost, strings.Replace(firingNotification, "node-condition-k8s", "other", 1)))
+	assert.Empty(t, events)
+}
+
+func Test_webhookHandler_token(t *testing.T) {
+	mClient := &mockAlertClient{}
+	mClient.On("GetAlerts", mock.Anything).Return(response1(), false, nil).Once()
+	s, err := NewSyncer(mClient, logr.Discard(), prometheus.NewRegistry(), singleRule(`labels["instance"] == FullName`), SyncerOptions{SyncInterval: time.Minute, FetchTimeout: time.Minute})
+	assert.NoError(t, err)
+	s.SyncOnce()
+	h := NewWebhookHandler(s, logr.Discard(), prometheus.NewRegistry(), "node-condition-k8s", "s3cret")
+
+	tests := []struct {
+		authorization string
+		want          int
+	}{
+		{want: http.StatusUnauthorized},
+		{authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{authorization: "Basic czNjcmV0", want: http.StatusUnauthorized},
+		{authorization: "Bearer s3cret", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(firingNotification))
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		h.ServeHTTP(rec, r)
+		assert.Equal(t, tt.want, rec.Code, tt.authorization)
+		if tt.want == http.StatusUnauthorized {
+			assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+	alerts, _, err := s.Get(namedNode("node2"), testRule)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+}
+
+func post(h http.Handler, method, body string) int {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, "/webhook", strings.NewReader(body)))
+	return rec.Code
+}
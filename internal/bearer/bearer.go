@@ -0,0 +1,18 @@
+// Package bearer authenticates HTTP requests with a shared bearer token
+package bearer
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Authorized returns true if token is empty, or if r carries token in its
+// Authorization header. Tokens are compared in constant time.
+func Authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,33 @@
+package bearer
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          bool
+	}{
+		{name: "no token", want: true},
+		{name: "no token with header", authorization: "Bearer s3cret", want: true},
+		{name: "valid", token: "s3cret", authorization: "Bearer s3cret", want: true},
+		{name: "missing", token: "s3cret"},
+		{name: "wrong", token: "s3cret", authorization: "Bearer guess"},
+		{name: "basic", token: "s3cret", authorization: "Basic s3cret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			assert.Equal(t, tt.want, Authorized(r, tt.token))
+		})
+	}
+}
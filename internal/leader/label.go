@@ -0,0 +1,77 @@
+// Package leader marks the pod of the elected leader, so that traffic only the
+// leader serves can be routed to it
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Label is set to "true" on the pod of the elected leader. A Service selecting it
+// only sends traffic to the leader.
+const Label = "sciuro.cloudflare.com/leader"
+
+// retryInterval is the time between attempts to label the pod
+const retryInterval = 5 * time.Second
+
+var leaderValue = "true"
+
+// PodLabeler sets Label on the pod of this replica once it is elected leader
+type PodLabeler struct {
+	c   client.Client
+	log logr.Logger
+	pod types.NamespacedName
+}
+
+var _ manager.LeaderElectionRunnable = &PodLabeler{}
+
+// NewPodLabeler returns a PodLabeler patching the named pod through c
+func NewPodLabeler(c client.Client, log logr.Logger, pod types.NamespacedName) *PodLabeler {
+	return &PodLabeler{c: c, log: log, pod: pod}
+}
+
+// Unlabel removes Label from the pod. A restarted container keeps the labels of its
+// pod, so this must be done before leader election starts.
+func (l *PodLabeler) Unlabel(ctx context.Context) error {
+	return l.patch(ctx, nil)
+}
+
+// Start sets Label on the pod, retrying until it succeeds or ctx is done
+func (l *PodLabeler) Start(ctx context.Context) error {
+	err := wait.PollUntilContextCancel(ctx, retryInterval, true, func(ctx context.Context) (bool, error) {
+		if err := l.patch(ctx, &leaderValue); err != nil {
+			l.log.Error(err, "could not label the pod of the leader, retrying", "pod", l.pod)
+			return false, nil
+		}
+		l.log.Info("labeled the pod of the leader", "pod", l.pod)
+		return true, nil
+	})
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (l *PodLabeler) NeedLeaderElection() bool {
+	return true
+}
+
+// patch sets Label to value with a merge patch, removing it when value is nil
+func (l *PodLabeler) patch(ctx context.Context, value *string) error {
+	raw, err := json.Marshal(map[string]any{"metadata": map[string]any{"labels": map[string]*string{Label: value}}})
+	if err != nil {
+		return err
+	}
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: l.pod.Name, Namespace: l.pod.Namespace}}
+	return l.c.Patch(ctx, pod, client.RawPatch(types.MergePatchType, raw))
+}
@@ -0,0 +1,42 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPodLabeler(t *testing.T) {
+	name := types.NamespacedName{Namespace: "node-remediation", Name: "sciuro-0"}
+	c := fake.NewClientBuilder().WithObjects(&corev1.Pod{ObjectMeta: v1.ObjectMeta{
+		Name:      name.Name,
+		Namespace: name.Namespace,
+		// left over from before the container restarted
+		Labels: map[string]string{"app": "sciuro", Label: "true"},
+	}}).Build()
+	l := NewPodLabeler(c, logr.Discard(), name)
+	labels := func() map[string]string {
+		pod := &corev1.Pod{}
+		assert.NoError(t, c.Get(context.Background(), name, pod))
+		return pod.Labels
+	}
+
+	assert.NoError(t, l.Unlabel(context.Background()))
+	assert.Equal(t, map[string]string{"app": "sciuro"}, labels())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- l.Start(ctx) }()
+	assert.Eventually(t, func() bool { return labels()[Label] == "true" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "sciuro", labels()["app"])
+	cancel()
+	assert.NoError(t, <-done)
+	assert.True(t, l.NeedLeaderElection())
+}
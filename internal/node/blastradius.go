@@ -0,0 +1,232 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/sciuro/internal/bearer"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// BlastRadius limits how many nodes may have a NodeCondition of the same type become
+// True within a window. A zero MaxNodes or MaxPercent does not limit.
+type BlastRadius struct {
+	// MaxNodes is the number of nodes
+	MaxNodes int
+	// MaxPercent is the percentage of all nodes
+	MaxPercent float64
+	// Window is the time the limits apply to
+	Window time.Duration
+}
+
+// BlastRadiusGuard holds back NodeConditions from becoming True once more nodes than
+// allowed by a BlastRadius would have them become True, as happens when an alert rule
+// or CEL expression breaks and suddenly matches every node.
+//
+// A condition type that exceeded the limit stays blocked until the number of nodes it
+// would become True on falls within the limit for a whole window, or until an operator
+// acknowledges it. An acknowledged condition type is not limited until its rate falls.
+//
+// Served over HTTP, GET lists the blocked condition types and POST with a conditionType
+// parameter acknowledges one. When a token is set, requests must carry it as a bearer
+// token. The state is kept in memory, so it must only be served by the leader.
+type BlastRadiusGuard struct {
+	limits   BlastRadius
+	token    string
+	nodes    client.Reader
+	log      logr.Logger
+	blocked  *prometheus.GaugeVec
+	heldBack *prometheus.CounterVec
+	now      func() time.Time
+
+	mu      sync.Mutex
+	windows map[corev1.NodeConditionType]*blastWindow
+}
+
+// blastWindow is what a condition type became True on within a window
+type blastWindow struct {
+	start time.Time
+	limit int
+	// admitted are the nodes the condition type became True on
+	admitted map[string]struct{}
+	// attempted are the nodes the condition type was to become True on
+	attempted map[string]struct{}
+	// blockedSince is set while the condition type is blocked
+	blockedSince time.Time
+	acked        bool
+}
+
+// BlockedCondition is a condition type held back by a BlastRadiusGuard
+type BlockedCondition struct {
+	ConditionType corev1.NodeConditionType `json:"conditionType"`
+	Since         time.Time                `json:"since"`
+	Nodes         int                      `json:"nodes"`
+	Limit         int                      `json:"limit"`
+}
+
+// NewBlastRadiusGuard returns a BlastRadiusGuard enforcing limits, counting the nodes
+// listed through nodes for MaxPercent. An empty token serves HTTP without
+// authentication.
+func NewBlastRadiusGuard(nodes client.Reader, log logr.Logger, prom prometheus.Registerer, limits BlastRadius, token string) *BlastRadiusGuard {
+	blocked := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "reconcile",
+		Name:      "blast_radius_blocked",
+		Help:      "Whether conditions of a type are held back from becoming True by the blast radius guard",
+	}, []string{"condition"})
+
+	heldBack := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "reconcile",
+		Name:      "blast_radius_held_back",
+		Help:      "Count of conditions held back from becoming True by the blast radius guard",
+	}, []string{"condition"})
+
+	prom.MustRegister(blocked, heldBack)
+
+	return &BlastRadiusGuard{
+		limits:   limits,
+		token:    token,
+		nodes:    nodes,
+		log:      log,
+		blocked:  blocked,
+		heldBack: heldBack,
+		now:      time.Now,
+		windows:  make(map[corev1.NodeConditionType]*blastWindow),
+	}
+}
+
+// admit returns true if conditionType may become True on the named node, along with
+// the number of nodes it may become True on in the current window
+func (g *BlastRadiusGuard) admit(ctx context.Context, conditionType corev1.NodeConditionType, nodeName string) (bool, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	w, ok := g.windows[conditionType]
+	if !ok || now.Sub(w.start) >= g.limits.Window {
+		next := &blastWindow{
+			start:     now,
+			limit:     g.limit(ctx),
+			admitted:  make(map[string]struct{}),
+			attempted: make(map[string]struct{}),
+		}
+		if ok && (!w.blockedSince.IsZero() || w.acked) {
+			// the rate has fallen once a whole window passed within the limit
+			if now.Sub(w.start) < 2*g.limits.Window && len(w.attempted) > w.limit {
+				next.blockedSince, next.acked = w.blockedSince, w.acked
+			} else if !w.blockedSince.IsZero() {
+				g.log.Info("unblocking condition type as the rate has fallen", "condition", conditionType)
+				g.blocked.WithLabelValues(string(conditionType)).Set(0)
+			}
+		}
+		g.windows[conditionType] = next
+		w = next
+	}
+
+	w.attempted[nodeName] = struct{}{}
+	if _, ok := w.admitted[nodeName]; ok || w.acked {
+		w.admitted[nodeName] = struct{}{}
+		return true, w.limit
+	}
+	if w.blockedSince.IsZero() && len(w.admitted) < w.limit {
+		w.admitted[nodeName] = struct{}{}
+		return true, w.limit
+	}
+	if w.blockedSince.IsZero() {
+		w.blockedSince = now
+		g.log.Info("blocking condition type as it would become True on too many nodes", "condition", conditionType, "limit", w.limit)
+		g.blocked.WithLabelValues(string(conditionType)).Set(1)
+	}
+	g.heldBack.WithLabelValues(string(conditionType)).Inc()
+	return false, w.limit
+}
+
+// limit returns the number of nodes a condition type may become True on in a window
+func (g *BlastRadiusGuard) limit(ctx context.Context) int {
+	limit := math.MaxInt
+	if g.limits.MaxNodes > 0 {
+		limit = g.limits.MaxNodes
+	}
+	if g.limits.MaxPercent > 0 {
+		nodes := &corev1.NodeList{}
+		if err := g.nodes.List(ctx, nodes, client.UnsafeDisableDeepCopy); err != nil {
+			g.log.Error(err, "could not list nodes, only limiting the number of nodes")
+		} else {
+			// rounding up admits at least one node, as a limit of none would block
+			// every condition type of a small cluster for good
+			limit = min(limit, max(1, int(math.Ceil(g.limits.MaxPercent/100*float64(len(nodes.Items))))))
+		}
+	}
+	return limit
+}
+
+// Blocked returns the condition types that are blocked
+func (g *BlastRadiusGuard) Blocked() []BlockedCondition {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	blocked := make([]BlockedCondition, 0)
+	for conditionType, w := range g.windows {
+		if !w.blockedSince.IsZero() {
+			blocked = append(blocked, BlockedCondition{
+				ConditionType: conditionType,
+				Since:         w.blockedSince,
+				Nodes:         len(w.attempted),
+				Limit:         w.limit,
+			})
+		}
+	}
+	sort.Slice(blocked, func(i, j int) bool { return blocked[i].ConditionType < blocked[j].ConditionType })
+	return blocked
+}
+
+// Acknowledge unblocks conditionType until its rate falls, returning false if it
+// was not blocked
+func (g *BlastRadiusGuard) Acknowledge(conditionType corev1.NodeConditionType) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	w, ok := g.windows[conditionType]
+	if !ok || w.blockedSince.IsZero() {
+		return false
+	}
+	w.blockedSince = time.Time{}
+	w.acked = true
+	g.log.Info("condition type acknowledged", "condition", conditionType)
+	g.blocked.WithLabelValues(string(conditionType)).Set(0)
+	return true
+}
+
+func (g *BlastRadiusGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !bearer.Authorized(r, g.token) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(g.Blocked()); err != nil {
+			g.log.Error(err, "could not write blocked condition types")
+		}
+	case http.MethodPost:
+		conditionType := r.FormValue("conditionType")
+		if conditionType == "" {
+			http.Error(w, "conditionType is required", http.StatusBadRequest)
+			return
+		}
+		if !g.Acknowledge(corev1.NodeConditionType(conditionType)) {
+			http.Error(w, "condition type is not blocked", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
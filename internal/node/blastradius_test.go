@@ -0,0 +1,257 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	"github.com/go-logr/logr"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const onFire corev1.NodeConditionType = "AlertManager_NodeOnFire"
+
+func newTestGuard(t *testing.T, limits BlastRadius, nodes int) (*BlastRadiusGuard, *time.Time) {
+	scheme := runtime.NewScheme()
+	assert.NilError(t, corev1.AddToScheme(scheme))
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for i := 0; i < nodes; i++ {
+		builder = builder.WithObjects(&corev1.Node{ObjectMeta: v1.ObjectMeta{Name: fmt.Sprintf("node%d", i)}})
+	}
+	g := NewBlastRadiusGuard(builder.Build(), logr.Discard(), prometheus.NewRegistry(), limits, "")
+	now := currentTime.Time
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestBlastRadiusGuard_admit(t *testing.T) {
+	g, now := newTestGuard(t, BlastRadius{MaxNodes: 2, Window: time.Minute}, 0)
+	admit := func(node string) bool {
+		ok, limit := g.admit(context.Background(), onFire, node)
+		assert.Equal(t, 2, limit)
+		return ok
+	}
+
+	assert.Assert(t, admit("node1"))
+	assert.Assert(t, admit("node2"))
+	assert.Assert(t, !admit("node3"))
+	// nodes already admitted in the window are not held back
+	assert.Assert(t, admit("node1"))
+	assert.Assert(t, !admit("node4"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.blocked.WithLabelValues(string(onFire))))
+	assert.Equal(t, 2.0, testutil.ToFloat64(g.heldBack.WithLabelValues(string(onFire))))
+	assert.DeepEqual(t, []BlockedCondition{{ConditionType: onFire, Since: currentTime.Time, Nodes: 4, Limit: 2}}, g.Blocked())
+
+	// other condition types are not affected
+	ok, _ := g.admit(context.Background(), "AlertManager_NodeFlooded", "node3")
+	assert.Assert(t, ok)
+
+	// the block stays while the rate does not fall
+	*now = now.Add(time.Minute)
+	assert.Assert(t, !admit("node3"))
+	assert.Assert(t, !admit("node4"))
+	assert.Assert(t, !admit("node5"))
+
+	*now = now.Add(time.Minute)
+	assert.Assert(t, !admit("node3"))
+
+	// a window within the limit unblocks
+	*now = now.Add(time.Minute)
+	assert.Assert(t, admit("node3"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(g.blocked.WithLabelValues(string(onFire))))
+	assert.DeepEqual(t, []BlockedCondition{}, g.Blocked())
+}
+
+func TestBlastRadiusGuard_Acknowledge(t *testing.T) {
+	g, now := newTestGuard(t, BlastRadius{MaxNodes: 1, Window: time.Minute}, 0)
+	admit := func(node string) bool {
+		ok, _ := g.admit(context.Background(), onFire, node)
+		return ok
+	}
+
+	assert.Assert(t, !g.Acknowledge(onFire))
+	assert.Assert(t, admit("node1"))
+	assert.Assert(t, !admit("node2"))
+	assert.Assert(t, g.Acknowledge(onFire))
+	assert.Equal(t, 0.0, testutil.ToFloat64(g.blocked.WithLabelValues(string(onFire))))
+	assert.Assert(t, admit("node2"))
+	assert.Assert(t, admit("node3"))
+
+	// acknowledged until the rate falls
+	*now = now.Add(time.Minute)
+	assert.Assert(t, admit("node4"))
+	assert.Assert(t, admit("node5"))
+	*now = now.Add(time.Minute)
+	assert.Assert(t, admit("node6"))
+	*now = now.Add(time.Minute)
+	assert.Assert(t, admit("node7"))
+	assert.Assert(t, !admit("node8"))
+}
+
+func TestBlastRadiusGuard_percent(t *testing.T) {
+	tests := []struct {
+		limits BlastRadius
+		nodes  int
+		want   int
+	}{
+		{limits: BlastRadius{MaxPercent: 20, Window: time.Minute}, nodes: 10, want: 2},
+		{limits: BlastRadius{MaxPercent: 25, Window: time.Minute}, nodes: 10, want: 3},
+		{limits: BlastRadius{MaxNodes: 1, MaxPercent: 20, Window: time.Minute}, nodes: 10, want: 1},
+		{limits: BlastRadius{MaxNodes: 5, MaxPercent: 20, Window: time.Minute}, nodes: 10, want: 2},
+		// small clusters still admit a node
+		{limits: BlastRadius{MaxPercent: 10, Window: time.Minute}, nodes: 5, want: 1},
+		{limits: BlastRadius{MaxPercent: 10, Window: time.Minute}, nodes: 0, want: 1},
+	}
+	for _, tt := range tests {
+		g, _ := newTestGuard(t, tt.limits, tt.nodes)
+		ok, limit := g.admit(context.Background(), onFire, "node1")
+		assert.Assert(t, ok, "%+v of %d nodes", tt.limits, tt.nodes)
+		assert.Equal(t, tt.want, limit, "%+v of %d nodes", tt.limits, tt.nodes)
+	}
+}
+
+func TestBlastRadiusGuard_ServeHTTP(t *testing.T) {
+	g, _ := newTestGuard(t, BlastRadius{MaxNodes: 1, Window: time.Minute}, 0)
+	g.admit(context.Background(), onFire, "node1")
+	g.admit(context.Background(), onFire, "node2")
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/blast-radius", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[{"conditionType":"AlertManager_NodeOnFire","since":"2020-03-18T13:17:58Z","nodes":2,"limit":1}]`+"\n", w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, url.Values{"conditionType": {"AlertManager_NodeFlooded"}}.Encode()).Code)
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodPost, url.Values{"conditionType": {string(onFire)}}.Encode()).Code)
+	assert.Equal(t, "[]\n", serve(http.MethodGet, "").Body.String())
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodDelete, "").Code)
+}
+
+func TestBlastRadiusGuard_ServeHTTP_token(t *testing.T) {
+	g, _ := newTestGuard(t, BlastRadius{MaxNodes: 1, Window: time.Minute}, 0)
+	g.token = "s3cret"
+	g.admit(context.Background(), onFire, "node1")
+	g.admit(context.Background(), onFire, "node2")
+
+	tests := []struct {
+		authorization string
+		want          int
+	}{
+		{want: http.StatusUnauthorized},
+		{authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{authorization: "Basic czNjcmV0", want: http.StatusUnauthorized},
+		{authorization: "Bearer s3cret", want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/blast-radius", strings.NewReader(url.Values{"conditionType": {string(onFire)}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, r)
+		assert.Equal(t, tt.want, w.Code, tt.authorization)
+	}
+	assert.DeepEqual(t, []BlockedCondition{}, g.Blocked())
+}
+
+func Test_updateNodeStatuses_blastRadius(t *testing.T) {
+	g, _ := newTestGuard(t, BlastRadius{MaxNodes: 1, Window: time.Minute}, 0)
+	recorder := record.NewFakeRecorder(10)
+	cache := &mockAlertCache{}
+	cache.On("Get", "node1", "default").Return([]alert.Alert{{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+	}}}, currentTime.Time, nil)
+	r := NewNodeStatusReconciler(nil, logr.Discard(), prometheus.NewRegistry(), recorder, cache, ReconcilerOptions{Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}, Guard: g}).(*nodeStatusReconciler)
+
+	fired := corev1.NodeCondition{
+		Type:               onFire,
+		Status:             statusTrue,
+		Reason:             reasonFiring,
+		Message:            "[P1]",
+		LastHeartbeatTime:  currentTime,
+		LastTransitionTime: currentTime,
+	}
+	node := newNode()
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	assert.DeepEqual(t, []corev1.NodeCondition{fired}, node.Status.Conditions)
+	assert.Equal(t, "Warning ConditionAdded AlertManager_NodeOnFire is firing with priority 1", <-recorder.Events)
+
+	// the same alert on a second node is held back
+	resolved := corev1.NodeCondition{
+		Type:               onFire,
+		Status:             statusFalse,
+		Reason:             reasonNotFiring,
+		LastHeartbeatTime:  oldTime,
+		LastTransitionTime: oldTime,
+	}
+	node = newNode(resolved)
+	node.Name = "node2"
+	cache.On("Get", "node2", "default").Return([]alert.Alert{{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+	}}}, currentTime.Time, nil)
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	resolved.LastHeartbeatTime = currentTime
+	assert.DeepEqual(t, []corev1.NodeCondition{resolved}, node.Status.Conditions)
+	assert.Equal(t, "Warning BlastRadiusExceeded Held back AlertManager_NodeOnFire from becoming True as it would on more than 1 nodes", <-recorder.Events)
+
+	// so is a condition that became Unknown while alerts were unavailable
+	unknown := corev1.NodeCondition{
+		Type:               onFire,
+		Status:             statusUnknown,
+		Reason:             reasonUnavailable,
+		Message:            "alertmanager unavailable",
+		LastHeartbeatTime:  oldTime,
+		LastTransitionTime: oldTime,
+	}
+	node = newNode(unknown)
+	node.Name = "node3"
+	cache.On("Get", "node3", "default").Return([]alert.Alert{{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+	}}}, currentTime.Time, nil)
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	unknown.LastHeartbeatTime = currentTime
+	assert.DeepEqual(t, []corev1.NodeCondition{unknown}, node.Status.Conditions)
+	assert.Equal(t, "Warning BlastRadiusExceeded Held back AlertManager_NodeOnFire from becoming True as it would on more than 1 nodes", <-recorder.Events)
+
+	// but not one that was True before alerts became unavailable
+	unknown.LastHeartbeatTime = oldTime
+	node = newNode(unknown)
+	node.Name = "node4"
+	node.Annotations = map[string]string{dampingAnnotation: `{"AlertManager_NodeOnFire":{"wasTrue":true}}`}
+	cache.On("Get", "node4", "default").Return([]alert.Alert{{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+	}}}, currentTime.Time, nil)
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	assert.DeepEqual(t, []corev1.NodeCondition{fired}, node.Status.Conditions)
+	assert.Equal(t, "Warning ConditionFiring AlertManager_NodeOnFire is firing with priority 1", <-recorder.Events)
+	_, hasState := node.Annotations[dampingAnnotation]
+	assert.Assert(t, !hasState)
+	assert.DeepEqual(t, []BlockedCondition{{ConditionType: onFire, Since: currentTime.Time, Nodes: 3, Limit: 1}}, g.Blocked())
+}
@@ -0,0 +1,99 @@
+package node
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// dampingAnnotation holds the damping state of the NodeConditions of a node, so that
+// it survives restarts
+const dampingAnnotation = "sciuro.cloudflare.com/damping"
+
+// Damping holds back the status changes of NodeConditions of flapping alerts. The zero
+// value changes the status of a NodeCondition as soon as an alert fires or resolves.
+type Damping struct {
+	// FiringObservations is the number of consecutive syncs an alert must be observed
+	// firing in before its NodeCondition becomes True
+	FiringObservations int
+	// MinFiringDuration is how long an alert must have been active before its
+	// NodeCondition becomes True. Alerts without an active time are not held back.
+	MinFiringDuration time.Duration
+	// MinResolvedDuration is how long an alert must have been absent before its
+	// NodeCondition becomes False
+	MinResolvedDuration time.Duration
+}
+
+// dampingState is the progress of a NodeCondition towards a status change
+type dampingState struct {
+	// Firing is the number of consecutive syncs the alert was observed firing in
+	Firing int `json:"firing,omitempty"`
+	// LastSeen is the sync the alert was last observed firing in
+	LastSeen *v1.Time `json:"lastSeen,omitempty"`
+	// AbsentSince is the sync the alert of a True NodeCondition was first missing from
+	AbsentSince *v1.Time `json:"absentSince,omitempty"`
+	// WasTrue is set while a NodeCondition is Unknown if it was True before its alerts
+	// became unavailable, so that it is not taken as newly firing once they are back
+	WasTrue bool `json:"wasTrue,omitempty"`
+}
+
+type dampingStates map[corev1.NodeConditionType]*dampingState
+
+// firing observes the alert of state firing in the sync at synced and returns true
+// once its NodeCondition may become True at current
+func (d Damping) firing(state *dampingState, synced, current v1.Time, activeAt time.Time) bool {
+	// a node is reconciled more than once per sync, and the state is stored with
+	// second precision
+	seen := synced.Rfc3339Copy()
+	if state.LastSeen == nil || seen.After(state.LastSeen.Time) {
+		state.Firing++
+		state.LastSeen = &seen
+	}
+	return state.Firing >= d.FiringObservations &&
+		(activeAt.IsZero() || current.Sub(activeAt) >= d.MinFiringDuration)
+}
+
+// resolved observes the alert of state missing in the sync at current and returns
+// true once its NodeCondition may become False
+func (d Damping) resolved(state *dampingState, current v1.Time) bool {
+	if state.AbsentSince == nil {
+		absent := current.Rfc3339Copy()
+		state.AbsentSince = &absent
+	}
+	return current.Sub(state.AbsentSince.Time) >= d.MinResolvedDuration
+}
+
+// readDampingStates returns the damping states stored on node. A malformed annotation
+// is logged and discarded, which at worst delays status changes.
+func readDampingStates(log logr.Logger, node *corev1.Node) dampingStates {
+	states := make(dampingStates)
+	raw, ok := node.Annotations[dampingAnnotation]
+	if !ok {
+		return states
+	}
+	if err := json.Unmarshal([]byte(raw), &states); err != nil {
+		log.Error(err, "discarding malformed damping annotation", "annotation", raw)
+		return make(dampingStates)
+	}
+	return states
+}
+
+// writeDampingStates stores states on node, removing the annotation when there are none
+func writeDampingStates(node *corev1.Node, states dampingStates) error {
+	if len(states) == 0 {
+		delete(node.Annotations, dampingAnnotation)
+		return nil
+	}
+	raw, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string, 1)
+	}
+	node.Annotations[dampingAnnotation] = string(raw)
+	return nil
+}
@@ -0,0 +1,184 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	"github.com/go-logr/logr"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDamping_firing(t *testing.T) {
+	damping := Damping{FiringObservations: 2, MinFiringDuration: 10 * time.Minute}
+	tests := []struct {
+		name     string
+		state    dampingState
+		activeAt time.Time
+		want     bool
+		wantN    int
+	}{
+		{
+			name:     "first observation",
+			activeAt: oldTime.Time,
+			wantN:    1,
+		},
+		{
+			name:     "same sync observed again",
+			state:    dampingState{Firing: 1, LastSeen: &currentTime},
+			activeAt: oldTime.Time,
+			wantN:    1,
+		},
+		{
+			name:     "enough observations",
+			state:    dampingState{Firing: 1, LastSeen: &oldTime},
+			activeAt: oldTime.Time,
+			want:     true,
+			wantN:    2,
+		},
+		{
+			name:     "not active long enough",
+			state:    dampingState{Firing: 1, LastSeen: &oldTime},
+			activeAt: currentTime.Add(-time.Minute),
+			wantN:    2,
+		},
+		{
+			name:  "unknown active time",
+			state: dampingState{Firing: 1, LastSeen: &oldTime},
+			want:  true,
+			wantN: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, damping.firing(&tt.state, currentTime, currentTime, tt.activeAt))
+			assert.Equal(t, tt.wantN, tt.state.Firing)
+			assert.Assert(t, tt.state.LastSeen.Equal(&currentTime))
+		})
+	}
+}
+
+func Test_updateNodeStatuses_damping(t *testing.T) {
+	const conditionType = "AlertManager_NodeOnFire"
+	nodeOnFire := []alert.Alert{{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+	}}}
+	start := currentTime.Time
+	steps := []struct {
+		name     string
+		at       time.Duration
+		alerts   []alert.Alert
+		fetchErr error
+		// pushed alerts amend the cache without a sync
+		pushed     bool
+		wantStatus corev1.ConditionStatus
+		wantState  bool
+	}{
+		{name: "first firing sync is held back", at: 0, alerts: nodeOnFire, wantState: true},
+		{name: "same sync again is held back", at: 0, alerts: nodeOnFire, wantState: true},
+		{name: "pushed alerts are held back", at: 30 * time.Second, alerts: nodeOnFire, pushed: true, wantState: true},
+		{name: "fetch errors keep the progress", at: time.Minute, fetchErr: errors.New("alertmanager unavailable"), wantState: true},
+		{name: "second firing sync fires", at: 2 * time.Minute, alerts: nodeOnFire, wantStatus: statusTrue},
+		{name: "absence is held back", at: 3 * time.Minute, wantStatus: statusTrue, wantState: true},
+		{name: "firing again is damped", at: 4 * time.Minute, alerts: nodeOnFire, wantStatus: statusTrue},
+		{name: "absence is held back again", at: 5 * time.Minute, wantStatus: statusTrue, wantState: true},
+		{name: "long absence resolves", at: 10 * time.Minute, wantStatus: statusFalse},
+		{name: "firing again is held back", at: 11 * time.Minute, alerts: nodeOnFire, wantStatus: statusFalse, wantState: true},
+		{name: "resolving again is damped", at: 12 * time.Minute, wantStatus: statusFalse},
+		{name: "fetch error makes the condition Unknown", at: 13 * time.Minute, fetchErr: errors.New("alertmanager unavailable"), wantStatus: statusUnknown},
+		{name: "firing after Unknown is held back", at: 14 * time.Minute, alerts: nodeOnFire, wantStatus: statusUnknown, wantState: true},
+		{name: "pushed alerts after Unknown are held back", at: 14*time.Minute + 30*time.Second, alerts: nodeOnFire, pushed: true, wantStatus: statusUnknown, wantState: true},
+		{name: "second firing sync after Unknown fires", at: 15 * time.Minute, alerts: nodeOnFire, wantStatus: statusTrue},
+		{name: "fetch error makes the True condition Unknown", at: 16 * time.Minute, fetchErr: errors.New("alertmanager unavailable"), wantStatus: statusUnknown, wantState: true},
+		{name: "firing after an outage of a True condition fires", at: 17 * time.Minute, alerts: nodeOnFire, wantStatus: statusTrue},
+	}
+
+	node := newNode(corev1.NodeCondition{Type: "Ready", Status: "True"})
+	var r *nodeStatusReconciler
+	var synced time.Time
+	for _, step := range steps {
+		// a fresh reconciler only knows what was kept on the node
+		r = NewNodeStatusReconciler(nil, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), nil, ReconcilerOptions{Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}, Damping: Damping{FiringObservations: 2, MinResolvedDuration: 5 * time.Minute}}).(*nodeStatusReconciler)
+		if !step.pushed {
+			synced = start.Add(step.at)
+		}
+		cache := &mockAlertCache{synced: synced}
+		cache.On("Get", "node1", "default").Return(step.alerts, start.Add(step.at), step.fetchErr)
+		r.alertCache = cache
+
+		assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node), step.name)
+		var status corev1.ConditionStatus
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == conditionType {
+				status = condition.Status
+			}
+		}
+		assert.Equal(t, step.wantStatus, status, step.name)
+		_, hasState := node.Annotations[dampingAnnotation]
+		assert.Equal(t, step.wantState, hasState, step.name)
+
+		switch step.name {
+		case "firing again is damped":
+			assert.Equal(t, 1.0, testutil.ToFloat64(r.dampedTransitions.WithLabelValues(statusFalse)), step.name)
+		case "resolving again is damped":
+			assert.Equal(t, 1.0, testutil.ToFloat64(r.dampedTransitions.WithLabelValues(statusTrue)), step.name)
+		}
+	}
+}
+
+func Test_readDampingStates(t *testing.T) {
+	node := newNode()
+	assert.DeepEqual(t, dampingStates{}, readDampingStates(logr.Discard(), node))
+
+	lastSeen := v1.Date(2020, 3, 18, 13, 17, 58, 0, time.Local)
+	states := dampingStates{"AlertManager_NodeOnFire": {Firing: 2, LastSeen: &lastSeen}}
+	assert.NilError(t, writeDampingStates(node, states))
+	assert.Equal(t, `{"AlertManager_NodeOnFire":{"firing":2,"lastSeen":"`+lastSeen.UTC().Format(time.RFC3339)+`"}}`, node.Annotations[dampingAnnotation])
+	got := readDampingStates(logr.Discard(), node)
+	assert.Equal(t, 2, got["AlertManager_NodeOnFire"].Firing)
+	assert.Assert(t, got["AlertManager_NodeOnFire"].LastSeen.Equal(&lastSeen))
+
+	assert.NilError(t, writeDampingStates(node, dampingStates{}))
+	_, ok := node.Annotations[dampingAnnotation]
+	assert.Assert(t, !ok)
+
+	node.Annotations = map[string]string{dampingAnnotation: "{"}
+	assert.DeepEqual(t, dampingStates{}, readDampingStates(logr.Discard(), node))
+}
+
+func Test_Reconcile_dampingAnnotation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NilError(t, corev1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().
+		WithRuntimeObjects(newNode(corev1.NodeCondition{Type: "Ready", Status: "True"})).
+		WithScheme(scheme).
+		Build()
+	cache := &mockAlertCache{synced: currentTime.Time}
+	cache.On("Get", "node1", "default").Return([]alert.Alert{{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeOnFire"},
+	}}}, currentTime.Time, nil)
+	r := NewNodeStatusReconciler(c, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), cache, ReconcilerOptions{ResyncInterval: time.Minute, ReconcileTimeout: time.Minute, Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}, Damping: Damping{FiringObservations: 2}})
+
+	_, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "node1"}})
+	assert.NilError(t, err)
+
+	actual := &corev1.Node{}
+	assert.NilError(t, c.Get(context.Background(), types.NamespacedName{Name: "node1"}, actual))
+	assert.DeepEqual(t, []corev1.NodeCondition{{Type: "Ready", Status: "True"}}, actual.Status.Conditions)
+	assert.Equal(t, `{"AlertManager_NodeOnFire":{"firing":1,"lastSeen":"2020-03-18T13:17:58Z"}}`, actual.Annotations[dampingAnnotation])
+}
@@ -0,0 +1,76 @@
+package node
+
+import (
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// disabledAnnotation disables sciuro on a node. "true" or "freeze" leave the owned
+// NodeConditions as they are, "strip" removes them.
+const disabledAnnotation = "sciuro.cloudflare.com/disabled"
+
+type disabledMode string
+
+const (
+	disabledNone   disabledMode = ""
+	disabledFreeze disabledMode = "freeze"
+	disabledStrip  disabledMode = "strip"
+)
+
+// disabledModeOf returns how sciuro is disabled on node. Unknown values of the
+// annotation are logged and freeze the node, which is the least surprising.
+func disabledModeOf(log logr.Logger, node *corev1.Node) disabledMode {
+	raw, ok := node.Annotations[disabledAnnotation]
+	switch {
+	case !ok || raw == "false":
+		return disabledNone
+	case raw == "true" || raw == string(disabledFreeze):
+		return disabledFreeze
+	case raw == string(disabledStrip):
+		return disabledStrip
+	default:
+		log.Info("unknown value of disabled annotation, freezing the node", "annotation", raw)
+		return disabledFreeze
+	}
+}
+
+// strip removes the owned NodeConditions of node along with the taints and damping
+// state that sciuro added. Nodes sciuro cordoned stay cordoned.
+func (n *nodeStatusReconciler) strip(log logr.Logger, node *corev1.Node) {
+	conditions := node.Status.Conditions[:0:0]
+	for _, condition := range node.Status.Conditions {
+		if !n.owns(condition.Type) {
+			conditions = append(conditions, condition)
+			continue
+		}
+		log.Info("stripping condition of disabled node", "condition", condition.Type)
+		n.updateStatusCounter.WithLabelValues(string(condition.Status), "").Inc()
+		n.recorder.Eventf(node, corev1.EventTypeNormal, eventReasonConditionDeleted,
+			"Deleted %s as sciuro is disabled on the node", condition.Type)
+	}
+	node.Status.Conditions = conditions
+
+	taints := node.Spec.Taints[:0:0]
+	for _, taint := range node.Spec.Taints {
+		if strings.HasPrefix(taint.Key, taintKeyPrefix) {
+			log.Info("removing taint of disabled node", "taint", taint.Key)
+			continue
+		}
+		taints = append(taints, taint)
+	}
+	node.Spec.Taints = taints
+
+	delete(node.Annotations, dampingAnnotation)
+}
+
+// owns returns true if conditionType is owned by one of the rules
+func (n *nodeStatusReconciler) owns(conditionType corev1.NodeConditionType) bool {
+	for _, rule := range n.rules {
+		if strings.HasPrefix(string(conditionType), rule.ConditionPrefix) {
+			return true
+		}
+	}
+	return false
+}
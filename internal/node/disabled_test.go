@@ -0,0 +1,107 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/mock"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func Test_disabledModeOf(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		want        disabledMode
+	}{
+		{annotations: nil, want: disabledNone},
+		{annotations: map[string]string{disabledAnnotation: "false"}, want: disabledNone},
+		{annotations: map[string]string{disabledAnnotation: "true"}, want: disabledFreeze},
+		{annotations: map[string]string{disabledAnnotation: "freeze"}, want: disabledFreeze},
+		{annotations: map[string]string{disabledAnnotation: "strip"}, want: disabledStrip},
+		{annotations: map[string]string{disabledAnnotation: "yes"}, want: disabledFreeze},
+	}
+	for _, tt := range tests {
+		node := newNode()
+		node.Annotations = tt.annotations
+		assert.Equal(t, tt.want, disabledModeOf(logr.Discard(), node), "%v", tt.annotations)
+	}
+}
+
+func Test_Reconcile_disabled(t *testing.T) {
+	owned := corev1.NodeCondition{
+		Type:               "AlertManager_NodeOnFire",
+		Status:             "True",
+		Reason:             "AlertIsFiring",
+		Message:            "[P1]",
+		LastHeartbeatTime:  oldTime,
+		LastTransitionTime: oldTime,
+	}
+	ready := corev1.NodeCondition{Type: "Ready", Status: "True"}
+	foreign := corev1.Taint{Key: "example.com/maintenance", Effect: corev1.TaintEffectNoSchedule}
+	tests := []struct {
+		name            string
+		mode            string
+		wantConditions  []corev1.NodeCondition
+		wantTaints      []corev1.Taint
+		wantAnnotations map[string]string
+	}{
+		{
+			name:           "freeze",
+			mode:           "true",
+			wantConditions: []corev1.NodeCondition{ready, owned},
+			wantTaints: []corev1.Taint{
+				foreign,
+				{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectNoSchedule},
+			},
+			wantAnnotations: map[string]string{
+				disabledAnnotation: "true",
+				dampingAnnotation:  `{"AlertManager_NodeFlooded":{"firing":1}}`,
+			},
+		},
+		{
+			name:            "strip",
+			mode:            "strip",
+			wantConditions:  []corev1.NodeCondition{ready},
+			wantTaints:      []corev1.Taint{foreign},
+			wantAnnotations: map[string]string{disabledAnnotation: "strip"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			assert.NilError(t, corev1.AddToScheme(scheme))
+			node := newNode(ready, owned)
+			node.Annotations = map[string]string{
+				disabledAnnotation: tt.mode,
+				dampingAnnotation:  `{"AlertManager_NodeFlooded":{"firing":1}}`,
+			}
+			node.Spec.Taints = []corev1.Taint{
+				foreign,
+				{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectNoSchedule},
+			}
+			c := fake.NewClientBuilder().WithRuntimeObjects(node).WithScheme(scheme).Build()
+			// alerts are not fetched for disabled nodes
+			cache := &mockAlertCache{}
+			r := NewNodeStatusReconciler(c, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), cache, ReconcilerOptions{ResyncInterval: time.Minute, ReconcileTimeout: time.Minute, Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}})
+
+			_, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "node1"}})
+			assert.NilError(t, err)
+
+			actual := &corev1.Node{}
+			assert.NilError(t, c.Get(context.Background(), types.NamespacedName{Name: "node1"}, actual))
+			assert.DeepEqual(t, tt.wantConditions, actual.Status.Conditions)
+			assert.DeepEqual(t, tt.wantTaints, actual.Spec.Taints)
+			assert.DeepEqual(t, tt.wantAnnotations, actual.Annotations)
+			mock.AssertExpectationsForObjects(t, cache)
+		})
+	}
+}
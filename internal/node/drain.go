@@ -0,0 +1,307 @@
+package node
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// cordonedAnnotation holds the time sciuro cordoned the node, and marks the
+	// nodes sciuro may uncordon
+	cordonedAnnotation = "sciuro.cloudflare.com/cordoned"
+	// drainedAnnotation holds the time sciuro finished, or gave up, draining the node
+	drainedAnnotation = "sciuro.cloudflare.com/drained"
+	// mirrorPodAnnotation marks the mirror pods of static pods, which cannot be evicted
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+	// podNodeNameField selects the pods of a node
+	podNodeNameField = "spec.nodeName"
+
+	eventReasonCordoned      = "Cordoned"
+	eventReasonDrainDeferred = "DrainDeferred"
+	eventReasonDrained       = "Drained"
+	eventReasonDrainTimedOut = "DrainTimedOut"
+	eventReasonUncordoned    = "Uncordoned"
+	eventReasonUnmanagedPods = "UnmanagedPods"
+
+	evictionEvicted = "evicted"
+	evictionBlocked = "blocked"
+	evictionFailed  = "failed"
+
+	// drainRequeueInterval is the time between eviction attempts while a node drains
+	drainRequeueInterval = 15 * time.Second
+	// pendingExpiry is the time after which a node admitted to drain stops counting
+	// as draining if its cordon was never listed
+	pendingExpiry = 5 * time.Minute
+)
+
+// Drain decides which nodes are cordoned and drained
+type Drain struct {
+	// MaxPriority is the highest priority whose True NodeConditions drain the node
+	MaxPriority int
+	// MaxConcurrent is the number of nodes drained at once across the cluster
+	MaxConcurrent int
+	// Timeout is the time after which draining gives up evicting pods, leaving the
+	// node cordoned. A zero Timeout keeps evicting.
+	Timeout time.Duration
+	// EvictUnmanaged evicts pods without a controller, which deletes them for good.
+	// Otherwise they are left on the node.
+	EvictUnmanaged bool
+}
+
+// Drainer cordons nodes with True NodeConditions of important alerts and evicts their
+// pods through the Eviction API, so that PodDisruptionBudgets are respected. Pods of
+// DaemonSets and static pods are left alone, as are pods without a controller unless
+// Drain.EvictUnmanaged is set. Nothing would recreate them once evicted.
+//
+// Nodes sciuro cordoned are marked with an annotation, and only those are uncordoned
+// once no NodeCondition requires draining them any more. Nodes cordoned by someone else
+// are not drained.
+type Drainer struct {
+	limits    Drain
+	c         client.Client
+	pods      client.Reader
+	log       logr.Logger
+	recorder  record.EventRecorder
+	evictions *prometheus.CounterVec
+	now       func() time.Time
+
+	mu sync.Mutex
+	// pending are the nodes admitted to drain whose cordon may not be listed yet,
+	// along with when they were admitted
+	pending map[string]time.Time
+}
+
+// NewDrainer returns a Drainer enforcing limits, patching and listing nodes through c
+// and listing pods through pods, which must be able to select pods by spec.nodeName
+func NewDrainer(c client.Client, pods client.Reader, log logr.Logger, prom prometheus.Registerer, recorder record.EventRecorder, limits Drain) *Drainer {
+	evictions := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "drain",
+		Name:      "evictions",
+		Help:      "Count of pod evictions by result",
+	}, []string{"result"})
+
+	prom.MustRegister(evictions)
+
+	return &Drainer{
+		limits:    limits,
+		c:         c,
+		pods:      pods,
+		log:       log,
+		recorder:  recorder,
+		evictions: evictions,
+		now:       time.Now,
+		pending:   make(map[string]time.Time),
+	}
+}
+
+// draining returns true while node is cordoned by sciuro and not yet drained
+func draining(node *corev1.Node) bool {
+	_, cordoned := node.Annotations[cordonedAnnotation]
+	_, drained := node.Annotations[drainedAnnotation]
+	return cordoned && !drained
+}
+
+// drainCondition returns the NodeCondition of node requiring it to be drained, or an
+// empty type if none does. While the node is cordoned, NodeConditions whose alerts are
+// unavailable or whose resolution is held back keep requiring it.
+func (d *Drainer) drainCondition(node *corev1.Node, byRule []*ruleAlerts) corev1.NodeConditionType {
+	_, cordoned := node.Annotations[cordonedAnnotation]
+	for _, condition := range node.Status.Conditions {
+		ra := owner(byRule, condition.Type)
+		if ra == nil || condition.Status == statusFalse {
+			continue
+		}
+		priority, firing := ra.firing[condition.Type]
+		if condition.Status == statusTrue && firing && priority <= d.limits.MaxPriority {
+			return condition.Type
+		}
+		if cordoned && !firing {
+			return condition.Type
+		}
+	}
+	return ""
+}
+
+// update cordons, drains or uncordons node as its NodeConditions require. Pods are
+// only evicted once the cordon was written, so node must be as it was fetched apart
+// from its NodeConditions.
+func (d *Drainer) update(ctx context.Context, log logr.Logger, node *corev1.Node, byRule []*ruleAlerts) {
+	log = log.WithName("drain")
+	conditionType := d.drainCondition(node, byRule)
+	cordonedAt, cordoned := node.Annotations[cordonedAnnotation]
+	if cordoned || conditionType == "" {
+		d.forget(node.Name)
+	}
+
+	switch {
+	case conditionType == "" && cordoned:
+		log.Info("uncordoning node as no condition requires draining it")
+		node.Spec.Unschedulable = false
+		delete(node.Annotations, cordonedAnnotation)
+		delete(node.Annotations, drainedAnnotation)
+		d.recorder.Event(node, corev1.EventTypeNormal, eventReasonUncordoned, "Uncordoned as no condition requires draining any more")
+	case conditionType == "":
+	case !cordoned:
+		if node.Spec.Unschedulable {
+			log.Info("not draining node cordoned by someone else", "condition", conditionType)
+			return
+		}
+		if ok, count := d.admit(ctx, node.Name); !ok {
+			log.Info("deferring drain as too many nodes are draining", "condition", conditionType, "draining", count)
+			d.recorder.Eventf(node, corev1.EventTypeWarning, eventReasonDrainDeferred,
+				"Deferred draining for %s as %d nodes are draining", conditionType, count)
+			return
+		}
+		log.Info("cordoning node", "condition", conditionType)
+		node.Spec.Unschedulable = true
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string, 1)
+		}
+		node.Annotations[cordonedAnnotation] = d.now().UTC().Format(time.RFC3339)
+		d.recorder.Eventf(node, corev1.EventTypeNormal, eventReasonCordoned, "Cordoned to drain for %s", conditionType)
+	case draining(node):
+		d.drain(ctx, log, node, cordonedAt)
+	}
+}
+
+// admit returns true if nodeName may start draining, along with the number of other
+// nodes draining
+func (d *Drainer) admit(ctx context.Context, nodeName string) (bool, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// a node whose cordon failed to be written, or that was deleted or disabled
+	// since, must not keep others from draining for good
+	now := d.now()
+	for name, admitted := range d.pending {
+		if now.Sub(admitted) >= pendingExpiry {
+			delete(d.pending, name)
+		}
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := d.c.List(ctx, nodes, client.UnsafeDisableDeepCopy); err != nil {
+		d.log.Error(err, "could not list nodes, deferring drain")
+		return false, len(d.pending)
+	}
+	others := make(map[string]struct{}, len(d.pending))
+	for name := range d.pending {
+		others[name] = struct{}{}
+	}
+	for i := range nodes.Items {
+		if draining(&nodes.Items[i]) {
+			others[nodes.Items[i].Name] = struct{}{}
+		}
+	}
+	delete(others, nodeName)
+	if len(others) >= d.limits.MaxConcurrent {
+		return false, len(others)
+	}
+	d.pending[nodeName] = now
+	return true, len(others)
+}
+
+// forget stops counting nodeName as admitted to drain, as its cordon was listed or
+// it is no longer to be drained
+func (d *Drainer) forget(nodeName string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, nodeName)
+}
+
+// drain evicts the pods of the cordoned node, marking it drained once none are left
+// or the timeout passed
+func (d *Drainer) drain(ctx context.Context, log logr.Logger, node *corev1.Node, cordonedAt string) {
+	pods, unmanaged, err := d.podsToDrain(ctx, node.Name)
+	if err != nil {
+		log.Error(err, "could not list pods to evict")
+		return
+	}
+	now := d.now()
+	if len(pods) == 0 {
+		log.Info("node drained")
+		node.Annotations[drainedAnnotation] = now.UTC().Format(time.RFC3339)
+		d.recorder.Event(node, corev1.EventTypeNormal, eventReasonDrained, "Drained")
+		if len(unmanaged) > 0 {
+			log.Info("left pods without a controller on the node", "pods", unmanaged)
+			d.recorder.Eventf(node, corev1.EventTypeWarning, eventReasonUnmanagedPods,
+				"Left pods without a controller on the node, as evicting deletes them for good: %s", strings.Join(unmanaged, ", "))
+		}
+		return
+	}
+	since, err := time.Parse(time.RFC3339, cordonedAt)
+	if err != nil {
+		log.Error(err, "malformed cordon annotation, restarting the timeout", "annotation", cordonedAt)
+		node.Annotations[cordonedAnnotation] = now.UTC().Format(time.RFC3339)
+		since = now
+	}
+	if d.limits.Timeout > 0 && now.Sub(since) >= d.limits.Timeout {
+		log.Info("giving up draining node", "pods", len(pods))
+		node.Annotations[drainedAnnotation] = now.UTC().Format(time.RFC3339)
+		d.recorder.Eventf(node, corev1.EventTypeWarning, eventReasonDrainTimedOut,
+			"Gave up draining after %s with %d pods left", d.limits.Timeout, len(pods))
+		return
+	}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		podLog := log.WithValues("pod", client.ObjectKeyFromObject(pod))
+		eviction := &policyv1.Eviction{ObjectMeta: v1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+		err := d.c.SubResource("eviction").Create(ctx, pod, eviction)
+		switch {
+		case err == nil || k8serrors.IsNotFound(err):
+			d.evictions.WithLabelValues(evictionEvicted).Inc()
+			podLog.Info("evicted pod")
+		case k8serrors.IsTooManyRequests(err):
+			// a PodDisruptionBudget does not allow the eviction yet
+			d.evictions.WithLabelValues(evictionBlocked).Inc()
+			podLog.Info("eviction blocked by disruption budget")
+		default:
+			d.evictions.WithLabelValues(evictionFailed).Inc()
+			podLog.Error(err, "could not evict pod")
+		}
+	}
+}
+
+// podsToDrain returns the pods of the node that must be gone for it to be drained,
+// including those already terminating, along with the names of the pods without a
+// controller that are left on the node
+func (d *Drainer) podsToDrain(ctx context.Context, nodeName string) ([]corev1.Pod, []string, error) {
+	pods := &corev1.PodList{}
+	if err := d.pods.List(ctx, pods, client.MatchingFields{podNodeNameField: nodeName}); err != nil {
+		return nil, nil, err
+	}
+	remaining := make([]corev1.Pod, 0, len(pods.Items))
+	unmanaged := make([]string, 0)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+			continue
+		}
+		controller := v1.GetControllerOf(&pod)
+		if controller != nil && controller.Kind == "DaemonSet" {
+			continue
+		}
+		if controller == nil && !d.limits.EvictUnmanaged {
+			unmanaged = append(unmanaged, client.ObjectKeyFromObject(&pod).String())
+			continue
+		}
+		remaining = append(remaining, pod)
+	}
+	return remaining, unmanaged, nil
+}
@@ -0,0 +1,306 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	"github.com/go-logr/logr"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newTestDrainer(t *testing.T, limits Drain, funcs interceptor.Funcs, objs ...client.Object) (*Drainer, client.Client, *record.FakeRecorder) {
+	scheme := runtime.NewScheme()
+	assert.NilError(t, corev1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithIndex(&corev1.Pod{}, podNodeNameField, func(obj client.Object) []string {
+			return []string{obj.(*corev1.Pod).Spec.NodeName}
+		}).
+		WithInterceptorFuncs(funcs).
+		Build()
+	recorder := record.NewFakeRecorder(10)
+	d := NewDrainer(c, c, logr.Discard(), prometheus.NewRegistry(), recorder, limits)
+	d.now = func() time.Time { return currentTime.Time }
+	return d, c, recorder
+}
+
+func newPod(name string, mutate func(*corev1.Pod)) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node1"},
+	}
+	if mutate != nil {
+		mutate(pod)
+	}
+	return pod
+}
+
+// managed makes a pod owned by a ReplicaSet, which recreates it elsewhere once evicted
+func managed(pod *corev1.Pod) {
+	controller := true
+	pod.OwnerReferences = []v1.OwnerReference{{Kind: "ReplicaSet", Name: "app", Controller: &controller}}
+}
+
+func firingOnFire(priority int) []*ruleAlerts {
+	return []*ruleAlerts{{
+		rule:   Rule{Name: "default", ConditionPrefix: "AlertManager_"},
+		firing: map[corev1.NodeConditionType]int{onFire: priority},
+	}}
+}
+
+func TestDrainer_update(t *testing.T) {
+	limits := Drain{MaxPriority: 2, MaxConcurrent: 1, Timeout: 30 * time.Minute}
+	tests := []struct {
+		name           string
+		status         corev1.ConditionStatus
+		byRule         []*ruleAlerts
+		unschedulable  bool
+		annotations    map[string]string
+		wantCordoned   bool
+		wantAnnotation map[string]string
+		wantEvent      string
+	}{
+		{
+			name:           "important condition cordons",
+			status:         statusTrue,
+			byRule:         firingOnFire(1),
+			wantCordoned:   true,
+			wantAnnotation: map[string]string{cordonedAnnotation: "2020-03-18T13:17:58Z"},
+			wantEvent:      "Normal Cordoned Cordoned to drain for AlertManager_NodeOnFire",
+		},
+		{
+			name:   "unimportant condition does not cordon",
+			status: statusTrue,
+			byRule: firingOnFire(5),
+		},
+		{
+			name:          "node cordoned by someone else is left alone",
+			status:        statusTrue,
+			byRule:        firingOnFire(1),
+			unschedulable: true,
+			wantCordoned:  true,
+		},
+		{
+			name:           "resolved condition uncordons",
+			status:         statusFalse,
+			byRule:         firingOnFire(1)[:0],
+			unschedulable:  true,
+			annotations:    map[string]string{cordonedAnnotation: "2020-03-18T12:33:45Z", drainedAnnotation: "2020-03-18T12:35:00Z"},
+			wantAnnotation: map[string]string{},
+			wantEvent:      "Normal Uncordoned Uncordoned as no condition requires draining any more",
+		},
+		{
+			name:           "unavailable alerts keep the cordon",
+			status:         statusUnknown,
+			byRule:         []*ruleAlerts{{rule: Rule{Name: "default", ConditionPrefix: "AlertManager_"}}},
+			unschedulable:  true,
+			annotations:    map[string]string{cordonedAnnotation: "2020-03-18T12:33:45Z", drainedAnnotation: "2020-03-18T12:35:00Z"},
+			wantCordoned:   true,
+			wantAnnotation: map[string]string{cordonedAnnotation: "2020-03-18T12:33:45Z", drainedAnnotation: "2020-03-18T12:35:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _, recorder := newTestDrainer(t, limits, interceptor.Funcs{})
+			node := newNode(corev1.NodeCondition{Type: onFire, Status: tt.status})
+			node.Spec.Unschedulable = tt.unschedulable
+			node.Annotations = tt.annotations
+
+			d.update(context.Background(), logr.Discard(), node, tt.byRule)
+			assert.Equal(t, tt.wantCordoned, node.Spec.Unschedulable)
+			assert.DeepEqual(t, tt.wantAnnotation, node.Annotations)
+			if tt.wantEvent == "" {
+				assert.Equal(t, 0, len(recorder.Events))
+			} else {
+				assert.Equal(t, tt.wantEvent, <-recorder.Events)
+			}
+		})
+	}
+}
+
+func TestDrainer_drain(t *testing.T) {
+	daemonSet := true
+	d, c, recorder := newTestDrainer(t, Drain{MaxPriority: 2, MaxConcurrent: 1}, interceptor.Funcs{},
+		newPod("app", managed),
+		newPod("bare", nil),
+		newPod("daemon", func(pod *corev1.Pod) {
+			pod.OwnerReferences = []v1.OwnerReference{{Kind: "DaemonSet", Name: "daemon", Controller: &daemonSet}}
+		}),
+		newPod("static", func(pod *corev1.Pod) {
+			pod.Annotations = map[string]string{mirrorPodAnnotation: "hash"}
+		}),
+		newPod("job", func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodSucceeded }),
+		newPod("elsewhere", func(pod *corev1.Pod) { pod.Spec.NodeName = "node2" }),
+	)
+	node := newNode(corev1.NodeCondition{Type: onFire, Status: statusTrue})
+	node.Spec.Unschedulable = true
+	node.Annotations = map[string]string{cordonedAnnotation: "2020-03-18T13:10:00Z"}
+
+	d.update(context.Background(), logr.Discard(), node, firingOnFire(1))
+	assert.Assert(t, draining(node))
+	assert.Equal(t, 1.0, testutil.ToFloat64(d.evictions.WithLabelValues(evictionEvicted)))
+	pods := &corev1.PodList{}
+	assert.NilError(t, c.List(context.Background(), pods))
+	assert.Equal(t, 5, len(pods.Items))
+
+	d.update(context.Background(), logr.Discard(), node, firingOnFire(1))
+	assert.Assert(t, !draining(node))
+	assert.Equal(t, "2020-03-18T13:17:58Z", node.Annotations[drainedAnnotation])
+	assert.Equal(t, "Normal Drained Drained", <-recorder.Events)
+	assert.Equal(t, "Warning UnmanagedPods Left pods without a controller on the node, as evicting deletes them for good: default/bare", <-recorder.Events)
+}
+
+func TestDrainer_drainEvictUnmanaged(t *testing.T) {
+	d, c, recorder := newTestDrainer(t, Drain{MaxPriority: 2, MaxConcurrent: 1, EvictUnmanaged: true}, interceptor.Funcs{},
+		newPod("bare", nil))
+	node := newNode(corev1.NodeCondition{Type: onFire, Status: statusTrue})
+	node.Spec.Unschedulable = true
+	node.Annotations = map[string]string{cordonedAnnotation: "2020-03-18T13:10:00Z"}
+
+	d.update(context.Background(), logr.Discard(), node, firingOnFire(1))
+	assert.Assert(t, draining(node))
+	pods := &corev1.PodList{}
+	assert.NilError(t, c.List(context.Background(), pods))
+	assert.Equal(t, 0, len(pods.Items))
+
+	d.update(context.Background(), logr.Discard(), node, firingOnFire(1))
+	assert.Assert(t, !draining(node))
+	assert.Equal(t, "Normal Drained Drained", <-recorder.Events)
+	assert.Equal(t, 0, len(recorder.Events))
+}
+
+func TestDrainer_drainTimeout(t *testing.T) {
+	d, _, recorder := newTestDrainer(t, Drain{MaxPriority: 2, MaxConcurrent: 1, Timeout: 10 * time.Minute}, interceptor.Funcs{
+		SubResourceCreate: func(ctx context.Context, c client.Client, subResource string, obj client.Object, subResourceObj client.Object, opts ...client.SubResourceCreateOption) error {
+			return k8serrors.NewTooManyRequests("disruption budget", 10)
+		},
+	}, newPod("app", managed))
+	node := newNode(corev1.NodeCondition{Type: onFire, Status: statusTrue})
+	node.Spec.Unschedulable = true
+	node.Annotations = map[string]string{cordonedAnnotation: "2020-03-18T13:10:00Z"}
+
+	d.update(context.Background(), logr.Discard(), node, firingOnFire(1))
+	assert.Assert(t, draining(node))
+	assert.Equal(t, 1.0, testutil.ToFloat64(d.evictions.WithLabelValues(evictionBlocked)))
+
+	d.now = func() time.Time { return currentTime.Add(5 * time.Minute) }
+	d.update(context.Background(), logr.Discard(), node, firingOnFire(1))
+	assert.Assert(t, !draining(node))
+	assert.Assert(t, node.Spec.Unschedulable)
+	assert.Equal(t, "Warning DrainTimedOut Gave up draining after 10m0s with 1 pods left", <-recorder.Events)
+}
+
+func TestDrainer_admit(t *testing.T) {
+	other := newNode()
+	other.Name = "node2"
+	other.Annotations = map[string]string{cordonedAnnotation: "2020-03-18T13:10:00Z"}
+	drained := newNode()
+	drained.Name = "node3"
+	drained.Annotations = map[string]string{cordonedAnnotation: "2020-03-18T13:10:00Z", drainedAnnotation: "2020-03-18T13:12:00Z"}
+	d, _, recorder := newTestDrainer(t, Drain{MaxPriority: 2, MaxConcurrent: 2}, interceptor.Funcs{}, other, drained)
+
+	ok, count := d.admit(context.Background(), "node1")
+	assert.Assert(t, ok)
+	assert.Equal(t, 1, count)
+	// admitted nodes count before their cordon is listed
+	ok, count = d.admit(context.Background(), "node4")
+	assert.Assert(t, !ok)
+	assert.Equal(t, 2, count)
+	ok, _ = d.admit(context.Background(), "node1")
+	assert.Assert(t, ok)
+
+	node := newNode(corev1.NodeCondition{Type: onFire, Status: statusTrue})
+	node.Name = "node4"
+	d.update(context.Background(), logr.Discard(), node, firingOnFire(1))
+	assert.Assert(t, !node.Spec.Unschedulable)
+	assert.Equal(t, "Warning DrainDeferred Deferred draining for AlertManager_NodeOnFire as 2 nodes are draining", <-recorder.Events)
+}
+
+func TestDrainer_admitExpiry(t *testing.T) {
+	d, _, _ := newTestDrainer(t, Drain{MaxPriority: 2, MaxConcurrent: 1}, interceptor.Funcs{})
+
+	ok, _ := d.admit(context.Background(), "node1")
+	assert.Assert(t, ok)
+	ok, count := d.admit(context.Background(), "node2")
+	assert.Assert(t, !ok)
+	assert.Equal(t, 1, count)
+
+	// the cordon of node1 was never listed
+	d.now = func() time.Time { return currentTime.Add(pendingExpiry) }
+	ok, count = d.admit(context.Background(), "node2")
+	assert.Assert(t, ok)
+	assert.Equal(t, 0, count)
+}
+
+func Test_Reconcile_drainForget(t *testing.T) {
+	frozen := newNode(corev1.NodeCondition{Type: "Ready", Status: "True"})
+	frozen.Annotations = map[string]string{disabledAnnotation: "freeze"}
+	tests := []struct {
+		name     string
+		nodeName string
+	}{
+		{name: "deleted node", nodeName: "node2"},
+		{name: "frozen node", nodeName: "node1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, c, _ := newTestDrainer(t, Drain{MaxPriority: 2, MaxConcurrent: 1}, interceptor.Funcs{}, frozen.DeepCopy())
+			r := NewNodeStatusReconciler(c, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), &mockAlertCache{}, ReconcilerOptions{ResyncInterval: time.Minute, ReconcileTimeout: time.Minute, Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}, Drainer: d})
+			ok, _ := d.admit(context.Background(), tt.nodeName)
+			assert.Assert(t, ok)
+
+			_, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: tt.nodeName}})
+			assert.NilError(t, err)
+			ok, count := d.admit(context.Background(), "node3")
+			assert.Assert(t, ok)
+			assert.Equal(t, 0, count)
+		})
+	}
+}
+
+func Test_Reconcile_drain(t *testing.T) {
+	d, c, _ := newTestDrainer(t, Drain{MaxPriority: 2, MaxConcurrent: 1}, interceptor.Funcs{},
+		newNode(corev1.NodeCondition{Type: "Ready", Status: "True"}), newPod("app", managed))
+	cache := &mockAlertCache{}
+	cache.On("Get", "node1", "default").Return([]alert.Alert{{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+	}}}, currentTime.Time, nil)
+	r := NewNodeStatusReconciler(c, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), cache, ReconcilerOptions{ResyncInterval: time.Minute, ReconcileTimeout: time.Minute, Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}, Drainer: d})
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "node1"}}
+
+	// the first reconcile cordons, the second evicts and the third finds the node drained
+	for _, wantRequeue := range []time.Duration{drainRequeueInterval, drainRequeueInterval, time.Minute} {
+		result, err := r.Reconcile(context.Background(), request)
+		assert.NilError(t, err)
+		assert.Equal(t, wantRequeue, result.RequeueAfter)
+	}
+
+	actual := &corev1.Node{}
+	assert.NilError(t, c.Get(context.Background(), request.NamespacedName, actual))
+	assert.Assert(t, actual.Spec.Unschedulable)
+	assert.DeepEqual(t, map[string]string{
+		cordonedAnnotation: "2020-03-18T13:17:58Z",
+		drainedAnnotation:  "2020-03-18T13:17:58Z",
+	}, actual.Annotations)
+	pods := &corev1.PodList{}
+	assert.NilError(t, c.List(context.Background(), pods))
+	assert.Equal(t, 0, len(pods.Items))
+}
@@ -0,0 +1,72 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// invalidAlert identifies an alert of a node that could not be turned into a
+// NodeCondition
+type invalidAlert struct {
+	rule        string
+	reason      string
+	fingerprint model.Fingerprint
+}
+
+// skippedAlert is an invalid alert along with why it was skipped
+type skippedAlert struct {
+	invalidAlert
+	message string
+}
+
+func newSkippedAlert(rule Rule, al alert.Alert, reason string, err error) skippedAlert {
+	return skippedAlert{
+		invalidAlert: invalidAlert{rule: rule.Name, reason: reason, fingerprint: al.Labels.Fingerprint()},
+		message:      fmt.Sprintf("Skipped alert %s of rule %s: %v", al.Labels, rule.Name, err),
+	}
+}
+
+// recordInvalidAlerts records a Warning Event on the node for each skipped alert
+// that was not skipped by the previous reconcile of the node, so that an alert that
+// stays invalid is reported once. The invalid alerts of rules whose alerts are
+// unavailable are carried over, as they are not known to have changed.
+func (n *nodeStatusReconciler) recordInvalidAlerts(node *corev1.Node, byRule []*ruleAlerts, skipped []skippedAlert) {
+	n.invalidMu.Lock()
+	defer n.invalidMu.Unlock()
+	previous := n.invalidByNode[node.Name]
+	current := make(map[invalidAlert]struct{}, len(skipped))
+	for _, ra := range byRule {
+		if ra.fetchErr == nil {
+			continue
+		}
+		for key := range previous {
+			if key.rule == ra.rule.Name {
+				current[key] = struct{}{}
+			}
+		}
+	}
+	for _, s := range skipped {
+		if _, ok := previous[s.invalidAlert]; !ok {
+			n.recorder.Event(node, corev1.EventTypeWarning, eventReasonInvalidAlert, s.message)
+		}
+		current[s.invalidAlert] = struct{}{}
+	}
+	if len(current) == 0 {
+		delete(n.invalidByNode, node.Name)
+		return
+	}
+	if n.invalidByNode == nil {
+		n.invalidByNode = make(map[string]map[invalidAlert]struct{})
+	}
+	n.invalidByNode[node.Name] = current
+}
+
+// forgetInvalidAlerts drops the invalid alerts of a node that no longer exists
+func (n *nodeStatusReconciler) forgetInvalidAlerts(nodeName string) {
+	n.invalidMu.Lock()
+	defer n.invalidMu.Unlock()
+	delete(n.invalidByNode, nodeName)
+}
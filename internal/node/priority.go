@@ -0,0 +1,49 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// DefaultPriorityLabel is the label holding the priority of an alert
+	DefaultPriorityLabel = "priority"
+	// DefaultPriority is the priority of alerts without a valid priority
+	DefaultPriority = 9
+)
+
+// Priorities derives the priority of an alert from one of its labels. A lower
+// priority is more important.
+type Priorities struct {
+	// Label is the alert label holding the priority
+	Label string
+	// Mapping maps label values, such as severities, to priorities. Values
+	// that are not mapped must be integers.
+	Mapping map[string]int
+	// Default is the priority of alerts without the label, or with a value
+	// that is neither mapped nor an integer
+	Default int
+}
+
+// DefaultPriorities reads integer priorities from the priority label
+var DefaultPriorities = Priorities{Label: DefaultPriorityLabel, Default: DefaultPriority}
+
+// of returns the priority of al and whether al carries the priority label.
+// A malformed priority is returned as an error alongside the default priority.
+func (p Priorities) of(al alert.Alert) (int, bool, error) {
+	raw, ok := al.Labels[model.LabelName(p.Label)]
+	if !ok {
+		return p.Default, false, nil
+	}
+	if priority, ok := p.Mapping[string(raw)]; ok {
+		return priority, true, nil
+	}
+	priority, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return p.Default, true, fmt.Errorf("malformed alert priority %q", raw)
+	}
+	return priority, true, nil
+}
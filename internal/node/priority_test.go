@@ -0,0 +1,105 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	"github.com/go-logr/logr"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"gotest.tools/v3/assert"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPriorities_of(t *testing.T) {
+	severities := Priorities{
+		Label:   "severity",
+		Mapping: map[string]int{"critical": 1, "warning": 5},
+		Default: 7,
+	}
+	tests := []struct {
+		name        string
+		priorities  Priorities
+		labels      model.LabelSet
+		want        int
+		wantLabeled bool
+		wantErr     string
+	}{
+		{
+			name:        "integer",
+			priorities:  DefaultPriorities,
+			labels:      model.LabelSet{"priority": "3"},
+			want:        3,
+			wantLabeled: true,
+		},
+		{
+			name:       "missing",
+			priorities: DefaultPriorities,
+			labels:     model.LabelSet{"severity": "critical"},
+			want:       DefaultPriority,
+		},
+		{
+			name:        "malformed",
+			priorities:  DefaultPriorities,
+			labels:      model.LabelSet{"priority": "blah"},
+			want:        DefaultPriority,
+			wantLabeled: true,
+			wantErr:     `malformed alert priority "blah"`,
+		},
+		{
+			name:        "mapped",
+			priorities:  severities,
+			labels:      model.LabelSet{"severity": "critical", "priority": "3"},
+			want:        1,
+			wantLabeled: true,
+		},
+		{
+			name:        "unmapped integer",
+			priorities:  severities,
+			labels:      model.LabelSet{"severity": "2"},
+			want:        2,
+			wantLabeled: true,
+		},
+		{
+			name:        "unmapped",
+			priorities:  severities,
+			labels:      model.LabelSet{"severity": "info"},
+			want:        7,
+			wantLabeled: true,
+			wantErr:     `malformed alert priority "info"`,
+		},
+		{
+			name:       "missing with mapping",
+			priorities: severities,
+			labels:     model.LabelSet{"priority": "3"},
+			want:       7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, labeled, err := tt.priorities.of(alert.Alert{Alert: promv1.Alert{Labels: tt.labels}})
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+			} else {
+				assert.NilError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantLabeled, labeled)
+		})
+	}
+}
+
+func Test_nodeStatusReconciler_priority(t *testing.T) {
+	r := NewNodeStatusReconciler(nil, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), nil, ReconcilerOptions{Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}}).(*nodeStatusReconciler)
+
+	malformed := alert.Alert{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "P1"}}}
+	assert.Equal(t, DefaultPriority, r.priority(logr.Discard(), malformed))
+	assert.Equal(t, DefaultPriority, r.priority(logr.Discard(), malformed))
+	assert.Equal(t, 2.0, testutil.ToFloat64(r.invalidPriorities.WithLabelValues("NodeOnFire")))
+
+	valid := alert.Alert{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "HouseOnFire", "priority": "1"}}}
+	assert.Equal(t, 1, r.priority(logr.Discard(), valid))
+	assert.Equal(t, 0.0, testutil.ToFloat64(r.invalidPriorities.WithLabelValues("HouseOnFire")))
+}
@@ -4,80 +4,285 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudflare/sciuro/internal/alert"
 	"github.com/go-logr/logr"
-	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 const (
-	alertNameLabel         = "alertname"
-	alertPriorityLabel     = "priority"
-	defaultPriority        = 9
-	alertSummaryAnnotation = "summary"
-	reasonFiring           = "AlertIsFiring"
-	reasonNotFiring        = "AlertIsNotFiring"
-	reasonUnavailable      = "AlertsUnavailable"
-	statusTrue             = "True"
-	statusFalse            = "False"
-	statusUnknown          = "Unknown"
+	alertNameLabel    = "alertname"
+	reasonFiring      = "AlertIsFiring"
+	reasonNotFiring   = "AlertIsNotFiring"
+	reasonUnavailable = "AlertsUnavailable"
+	reasonSilenced    = "AlertIsSilenced"
+	reasonInhibited   = "AlertIsInhibited"
+	statusTrue        = "True"
+	statusFalse       = "False"
+	statusUnknown     = "Unknown"
+
+	// invalid alerts are counted by why they were skipped
+	invalidReasonEvaluation = "evaluation"
+	invalidReasonConversion = "conversion"
+
+	eventReasonInvalidAlert = "InvalidAlert"
+	eventReasonBlastRadius  = "BlastRadiusExceeded"
+
+	// condition transitions are recorded as Events on the node
+	eventReasonConditionAdded      = "ConditionAdded"
+	eventReasonConditionFiring     = "ConditionFiring"
+	eventReasonConditionResolved   = "ConditionResolved"
+	eventReasonConditionUnknown    = "ConditionUnknown"
+	eventReasonConditionDeleted    = "ConditionDeleted"
+	eventReasonConditionSuppressed = "ConditionSuppressed"
+
+	summaryAnnotation = "summary"
+
+	patchWritten = "written"
+	patchSkipped = "skipped"
+)
+
+// Rule owns the NodeConditions created from the alerts an alert.Cache rule
+// matched to the node
+type Rule struct {
+	// Name is the name of the rule in the alert.Cache
+	Name string
+	// ConditionPrefix is prepended to the NodeConditionType of the rule's
+	// NodeConditions. It must not be a prefix of another rule's ConditionPrefix.
+	ConditionPrefix string
+	// Templates render the NodeConditions of firing alerts. When nil the
+	// default templates are used.
+	Templates *ConditionTemplates
+	// Taints taint the node while NodeConditions of the rule are True. When nil
+	// the node is not tainted.
+	Taints Taints
+}
+
+// FetchErrorPolicy decides what happens to owned NodeConditions while the alerts of
+// their rule are unavailable
+type FetchErrorPolicy string
+
+const (
+	// FetchErrorUnknown sets owned NodeConditions to Unknown with the error as message
+	FetchErrorUnknown FetchErrorPolicy = "unknown"
+	// FetchErrorKeep leaves owned NodeConditions as they were, heartbeat included
+	FetchErrorKeep FetchErrorPolicy = "keep"
 )
 
+// UnmarshalText accepts the known policies only
+func (p *FetchErrorPolicy) UnmarshalText(text []byte) error {
+	switch policy := FetchErrorPolicy(text); policy {
+	case FetchErrorUnknown, FetchErrorKeep:
+		*p = policy
+		return nil
+	default:
+		return fmt.Errorf("unknown fetch error policy %q", text)
+	}
+}
+
+// SuppressedStatus is the status of the NodeConditions of alerts that are silenced or
+// inhibited in Alertmanager
+type SuppressedStatus corev1.ConditionStatus
+
+// UnmarshalText accepts the statuses of NodeConditions only
+func (s *SuppressedStatus) UnmarshalText(text []byte) error {
+	switch status := SuppressedStatus(text); status {
+	case statusTrue, statusFalse, statusUnknown:
+		*s = status
+		return nil
+	default:
+		return fmt.Errorf("unknown suppressed status %q", text)
+	}
+}
+
+// ValidateRules returns an error if the rules cannot tell apart the
+// NodeConditions they own
+func ValidateRules(rules []Rule) error {
+	if len(rules) == 0 {
+		return errors.New("at least one rule is required")
+	}
+	for i, a := range rules {
+		if a.ConditionPrefix == "" {
+			return fmt.Errorf("rule %q must have a condition prefix", a.Name)
+		}
+		// a prefix that does not survive sanitizing would make every condition
+		// type of the rule invalid
+		if !strings.HasPrefix(sanitizeConditionType(a.ConditionPrefix+"X"), a.ConditionPrefix) {
+			return fmt.Errorf("condition prefix %q of rule %q is not valid in a condition type", a.ConditionPrefix, a.Name)
+		}
+		if err := a.Taints.validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", a.Name, err)
+		}
+		for _, b := range rules[i+1:] {
+			if strings.HasPrefix(a.ConditionPrefix, b.ConditionPrefix) || strings.HasPrefix(b.ConditionPrefix, a.ConditionPrefix) {
+				return fmt.Errorf("condition prefixes of rules %q and %q overlap", a.Name, b.Name)
+			}
+		}
+	}
+	return nil
+}
+
 type nodeStatusReconciler struct {
 	c                   client.Client
 	log                 logr.Logger
+	recorder            record.EventRecorder
 	resyncInterval      time.Duration
 	reconcileTimeout    time.Duration
 	linger              time.Duration
+	heartbeatInterval   time.Duration
 	alertCache          alert.Cache
 	updateStatusCounter *prometheus.CounterVec
-	conditionPrefix     string
+	invalidPriorities   *prometheus.CounterVec
+	invalidAlerts       *prometheus.CounterVec
+	statusPatches       *prometheus.CounterVec
+	dampedTransitions   *prometheus.CounterVec
+	localSilences       prometheus.Gauge
+	rules               []Rule
+	priorities          Priorities
+	damping             Damping
+	fetchErrorPolicy    FetchErrorPolicy
+	suppressedStatus    SuppressedStatus
+	guard               *BlastRadiusGuard
+	drainer             *Drainer
+
+	silencesMu     sync.Mutex
+	silencesByNode map[string]int
+
+	// invalidByNode holds the invalid alerts of each node, which are only recorded
+	// as Events when they first become invalid
+	invalidMu     sync.Mutex
+	invalidByNode map[string]map[invalidAlert]struct{}
 }
 
 var _ reconcile.Reconciler = &nodeStatusReconciler{}
 
+// ReconcilerOptions configures a reconciler returned by NewNodeStatusReconciler
+type ReconcilerOptions struct {
+	// ResyncInterval is how often a node is reconciled without changes
+	ResyncInterval time.Duration
+	// ReconcileTimeout bounds the reconciliation of a node
+	ReconcileTimeout time.Duration
+	// Linger is how long False NodeConditions are retained
+	Linger time.Duration
+	// HeartbeatInterval is how often only heartbeats are patched
+	HeartbeatInterval time.Duration
+	// Rules own the NodeConditions of the alerts they match
+	Rules []Rule
+	// Priorities decide which alert wins a NodeConditionType, DefaultPriorities if
+	// its Label is empty
+	Priorities Priorities
+	// Damping holds back status changes of flapping alerts
+	Damping Damping
+	// FetchErrorPolicy applies while alerts are unavailable, FetchErrorUnknown if empty
+	FetchErrorPolicy FetchErrorPolicy
+	// SuppressedStatus applies to silenced or inhibited alerts, False if empty
+	SuppressedStatus SuppressedStatus
+	// Guard holds back NodeConditions becoming True on too many nodes
+	Guard *BlastRadiusGuard
+	// Drainer drains nodes with True NodeConditions of important alerts
+	Drainer *Drainer
+}
+
 // NewNodeStatusReconciler returns a reconcile.Reconciler that will PATCH the subresource
 // node/status with updates to NodeConditions from alerts specific to the node. As alerts
-// are not known ahead, the NodeConditionType is prefixed with conditionPrefix to allow the reconciler
-// to distinguish NodeConditions it "owns" from those it does not. It will not modify non-"owned"
-// NodeConditions.
+// are not known ahead, the NodeConditionType is prefixed with the ConditionPrefix of the rule
+// that matched the alert to allow the reconciler to distinguish NodeConditions it "owns" from
+// those it does not, and which rule owns them. It will not modify non-"owned" NodeConditions.
+// Only the changed NodeConditions are sent, as a strategic merge patch keyed by type that
+// conflicts, and is retried, when the node was written to since it was fetched.
+// The Rules must have passed ValidateRules.
 //
 // NodeConditions created from a given alert have the provided structure:
 //
 //	    	NodeCondition{
-//			    Type:               conditionPrefix + $labels.alertname
+//			    Type:               rule.ConditionPrefix + rendered type template, by default $labels.alertname
 //			    Status:             True - if firing,
 //			                        False if not firing,
 //			                        Unknown if alerts are unavailable
 //			    LastHeartbeatTime:  currentTime,
 //			    LastTransitionTime: currentTime if status changed,
-//			    Reason:             One of "AlertIsFiring", "AlertIsNotFiring", "AlertsUnavailable"
-//			    Message:            $annotations.summary if present
+//			    Reason:             rendered reason template if firing, by default "AlertIsFiring",
+//			                        otherwise one of "AlertIsNotFiring", "AlertsUnavailable",
+//			                        "AlertIsSilenced", "AlertIsInhibited", "AlertIsSilencedOnNode"
+//			    Message:            rendered message template if firing, by default
+//			                        [P$priority] followed by $annotations.summary if present,
+//			                        the error if alerts are unavailable
 //		    }
 //
-// The linger option sets the minimum time a NodeCondition with a False Status will be retained.
+// Alerts silenced or inhibited in Alertmanager have their NodeConditions set to
+// SuppressedStatus straight away, with a reason telling why. Alerts silenced by the
+// sciuro.cloudflare.com/silences annotation of a node have their NodeConditions set to
+// False. Expired silences are removed from the annotation, and the active ones are
+// counted.
+//
+// Nodes annotated with sciuro.cloudflare.com/disabled are left alone: "true" or "freeze"
+// keep their NodeConditions as they are, while "strip" deletes the owned NodeConditions
+// along with the taints sciuro added.
+//
+// Every change of status, and every NodeCondition added or deleted, is recorded as an
+// Event on the node: a Warning for NodeConditions becoming True or Unknown, with the
+// priority and summary of the alert, and a Normal Event for those resolving or deleted.
+//
+// The Linger option sets the minimum time a NodeCondition with a False Status will be retained.
 // A NodeCondition that has been False for the entire linger duration will be removed from
 // the node. Setting this to a zero duration disables this behavior.
+//
+// The HeartbeatInterval option sets how often a node is patched when nothing but the
+// LastHeartbeatTime of its NodeConditions changed. Such a patch is skipped until the
+// heartbeats written last are older than the interval. Setting this to a zero duration
+// patches every heartbeat.
+//
+// The Damping option holds back status changes of flapping alerts: a NodeCondition
+// becomes True once its alert was firing for enough syncs and long enough, and False
+// once its alert was absent long enough. The progress of NodeConditions that are held
+// back is kept in an annotation of the node.
+//
+// While the alerts of a rule are unavailable, its NodeConditions are either set to Unknown
+// with the error as message, or kept as they were, as decided by FetchErrorPolicy.
+//
+// NodeConditions that would become True on too many nodes at once are held back by the
+// Guard and recorded as a Warning Event on the node. A nil Guard does not hold back.
+//
+// Nodes with True NodeConditions of important alerts are cordoned and drained by the
+// Drainer, and uncordoned once their NodeConditions resolve. A nil Drainer does not drain.
+//
+// When several alerts render the same NodeConditionType, the one with the lowest priority
+// as given by Priorities wins. Alerts with a malformed priority are logged, counted and
+// given the default priority.
+//
+// Alerts that cannot be turned into a NodeCondition, because the rule could not be
+// evaluated for them or their condition could not be rendered, are skipped without
+// holding up the other alerts of the node. Each is logged and counted, and recorded as
+// a Warning Event on the node when it becomes invalid.
 func NewNodeStatusReconciler(
 	c client.Client,
 	log logr.Logger,
 	prom prometheus.Registerer,
-	resyncInterval,
-	reconcileTimeout,
-	linger time.Duration,
+	recorder record.EventRecorder,
 	ac alert.Cache,
-	conditionPrefix string,
+	opts ReconcilerOptions,
 ) reconcile.Reconciler {
+	if opts.Priorities.Label == "" {
+		opts.Priorities = DefaultPriorities
+	}
+	if opts.FetchErrorPolicy == "" {
+		opts.FetchErrorPolicy = FetchErrorUnknown
+	}
+	if opts.SuppressedStatus == "" {
+		opts.SuppressedStatus = statusFalse
+	}
 
 	updateStatusCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Subsystem: "reconcile",
@@ -85,17 +290,62 @@ func NewNodeStatusReconciler(
 		Help:      "Count of reconciler status changes",
 	}, []string{"old_status", "new_status"})
 
-	prom.MustRegister(updateStatusCounter)
+	invalidPriorities := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "reconcile",
+		Name:      "invalid_priorities",
+		Help:      "Count of alerts given the default priority because their priority was malformed",
+	}, []string{"alertname"})
+
+	invalidAlerts := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sciuro",
+		Name:      "invalid_alerts_total",
+		Help:      "Count of alerts skipped because they could not be turned into a condition",
+	}, []string{"rule", "reason"})
+
+	statusPatches := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "reconcile",
+		Name:      "status_patches",
+		Help:      "Count of node status patches written, or skipped as only heartbeats changed",
+	}, []string{"result"})
+
+	dampedTransitions := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "reconcile",
+		Name:      "damped_transitions",
+		Help:      "Count of condition status changes held back until the alert flapped back",
+	}, []string{"new_status"})
+
+	localSilences := prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "reconcile",
+		Name:      "local_silences",
+		Help:      "Number of active silences in node annotations",
+	})
+
+	prom.MustRegister(updateStatusCounter, invalidPriorities, invalidAlerts, statusPatches, dampedTransitions, localSilences)
 
 	return &nodeStatusReconciler{
 		c:                   c,
 		log:                 log,
-		resyncInterval:      resyncInterval,
-		reconcileTimeout:    reconcileTimeout,
-		linger:              linger,
+		recorder:            recorder,
+		resyncInterval:      opts.ResyncInterval,
+		reconcileTimeout:    opts.ReconcileTimeout,
+		linger:              opts.Linger,
+		heartbeatInterval:   opts.HeartbeatInterval,
 		alertCache:          ac,
 		updateStatusCounter: updateStatusCounter,
-		conditionPrefix:     conditionPrefix,
+		invalidPriorities:   invalidPriorities,
+		invalidAlerts:       invalidAlerts,
+		statusPatches:       statusPatches,
+		dampedTransitions:   dampedTransitions,
+		localSilences:       localSilences,
+		rules:               opts.Rules,
+		priorities:          opts.Priorities,
+		damping:             opts.Damping,
+		fetchErrorPolicy:    opts.FetchErrorPolicy,
+		suppressedStatus:    opts.SuppressedStatus,
+		guard:               opts.Guard,
+		drainer:             opts.Drainer,
+		silencesByNode:      make(map[string]int),
+		invalidByNode:       make(map[string]map[invalidAlert]struct{}),
 	}
 }
 
@@ -104,80 +354,295 @@ func (n *nodeStatusReconciler) Reconcile(ctx context.Context, request reconcile.
 	ctx, cancel := context.WithTimeout(ctx, n.reconcileTimeout)
 	defer cancel()
 
+	// the patch is computed from the node as it was fetched, so a concurrent
+	// write to the node makes it conflict and is retried with a fresh node
+	var drain bool
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		drain, err = n.reconcileNode(ctx, log, request.NamespacedName)
+		return err
+	})
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if drain {
+		return reconcile.Result{RequeueAfter: min(n.resyncInterval, drainRequeueInterval)}, nil
+	}
+	return reconcile.Result{RequeueAfter: n.resyncInterval}, nil
+}
+
+// reconcileNode patches the owned conditions of the node with a strategic merge
+// patch keyed by condition type, leaving the conditions of others untouched. It
+// returns true while the node is draining.
+func (n *nodeStatusReconciler) reconcileNode(ctx context.Context, log logr.Logger, name types.NamespacedName) (bool, error) {
 	currentNode := &corev1.Node{}
-	err := n.c.Get(ctx, request.NamespacedName, currentNode)
+	err := n.c.Get(ctx, name, currentNode)
 	if k8serrors.IsNotFound(err) {
 		log.Error(err, "could not find Node")
-		return reconcile.Result{}, nil
+		n.setLocalSilences(name.Name, 0)
+		n.forgetInvalidAlerts(name.Name)
+		if n.drainer != nil {
+			n.drainer.forget(name.Name)
+		}
+		return false, nil
 	}
 	if err != nil {
 		log.Error(err, "could not fetch Node")
-		return reconcile.Result{}, err
+		return false, err
 	}
 	desiredNode := currentNode.DeepCopy()
-	if err := n.updateNodeStatuses(log, desiredNode); err != nil {
-		log.Error(err, "could not update node status")
-		return reconcile.Result{}, err
+	switch disabledModeOf(log, currentNode) {
+	case disabledFreeze:
+		log.V(1).Info("sciuro is disabled on the node, leaving its conditions as they are")
+		if n.drainer != nil {
+			n.drainer.forget(name.Name)
+		}
+		return false, nil
+	case disabledStrip:
+		n.strip(log, desiredNode)
+	default:
+		if err := n.updateNodeStatuses(ctx, log, desiredNode); err != nil {
+			log.Error(err, "could not update node status")
+			return false, err
+		}
 	}
-	if equality.Semantic.DeepEqual(desiredNode, currentNode) {
-		return reconcile.Result{RequeueAfter: n.resyncInterval}, nil
+	if !equality.Semantic.DeepEqual(desiredNode.Status, currentNode.Status) {
+		if err := n.patchStatus(ctx, log, currentNode, desiredNode); err != nil {
+			return false, err
+		}
 	}
-	patch := client.MergeFrom(currentNode)
-	if err := n.c.Status().Patch(ctx, desiredNode, patch); err != nil {
-		log.Error(err, "could not patch node")
-		return reconcile.Result{}, err
+	if !equality.Semantic.DeepEqual(desiredNode.Annotations, currentNode.Annotations) ||
+		!equality.Semantic.DeepEqual(desiredNode.Spec, currentNode.Spec) {
+		// annotations and the spec are not part of the status subresource, and the
+		// status patch has moved the node on to a new resource version
+		base := currentNode.DeepCopy()
+		base.Status = desiredNode.Status
+		patch := client.StrategicMergeFrom(base, client.MergeFromWithOptimisticLock{})
+		if err := n.c.Patch(ctx, desiredNode.DeepCopy(), patch); err != nil {
+			if k8serrors.IsConflict(err) {
+				log.Info("node changed while reconciling, retrying")
+			} else {
+				log.Error(err, "could not patch node")
+			}
+			return false, err
+		}
 	}
-	return reconcile.Result{RequeueAfter: n.resyncInterval}, nil
+	return n.drainer != nil && draining(desiredNode), nil
+}
+
+// patchStatus patches the status of current to that of desired, unless only recent
+// heartbeats changed. The resource version of current is moved on to the patched one.
+func (n *nodeStatusReconciler) patchStatus(ctx context.Context, log logr.Logger, current, desired *corev1.Node) error {
+	if n.onlyRecentHeartbeats(current, desired) {
+		n.statusPatches.WithLabelValues(patchSkipped).Inc()
+		return nil
+	}
+	patched := desired.DeepCopy()
+	patch := client.StrategicMergeFrom(current, client.MergeFromWithOptimisticLock{})
+	if err := n.c.Status().Patch(ctx, patched, patch); err != nil {
+		if k8serrors.IsConflict(err) {
+			log.Info("node changed while reconciling, retrying")
+		} else {
+			log.Error(err, "could not patch node")
+		}
+		return err
+	}
+	n.statusPatches.WithLabelValues(patchWritten).Inc()
+	current.ResourceVersion = patched.ResourceVersion
+	return nil
+}
+
+// onlyRecentHeartbeats returns true if the status of desired differs from current only in the
+// LastHeartbeatTime of NodeConditions whose heartbeat is within the heartbeat interval
+func (n *nodeStatusReconciler) onlyRecentHeartbeats(current, desired *corev1.Node) bool {
+	if n.heartbeatInterval == 0 || len(current.Status.Conditions) != len(desired.Status.Conditions) {
+		return false
+	}
+	withoutHeartbeats := desired.Status.DeepCopy()
+	for i := range withoutHeartbeats.Conditions {
+		existing, updated := &current.Status.Conditions[i], &withoutHeartbeats.Conditions[i]
+		if existing.Type != updated.Type || updated.LastHeartbeatTime.Sub(existing.LastHeartbeatTime.Time) >= n.heartbeatInterval {
+			return false
+		}
+		updated.LastHeartbeatTime = existing.LastHeartbeatTime
+	}
+	return equality.Semantic.DeepEqual(*withoutHeartbeats, current.Status)
 }
 
-func (n *nodeStatusReconciler) updateNodeStatuses(log logr.Logger, node *corev1.Node) error {
-	alerts, currentTime, fetchErr := n.alertCache.Get(node.Name)
-	current := v1.NewTime(currentTime)
-
-	incomingConditions := make(map[corev1.NodeConditionType]*conditionAndPriority, len(alerts))
-	// only if we have valid results (no err) will we need converted conditions
-	if fetchErr == nil {
-		for _, al := range alerts {
-			condAndPriority, err := convertAlertToCondition(log, al, current, n.conditionPrefix)
-			if err != nil {
-				return err
+// ruleAlerts are the conditions a rule derives from the alerts of a node
+type ruleAlerts struct {
+	rule    Rule
+	current v1.Time
+	// synced is the time of the sync the alerts are from, which only advances
+	// with syncs and not with pushed alerts
+	synced   v1.Time
+	fetchErr error
+	incoming map[corev1.NodeConditionType]*conditionAndPriority
+	// suppressed holds the NodeConditions of silenced or inhibited alerts, which are
+	// not firing
+	suppressed map[corev1.NodeConditionType]*conditionAndPriority
+	// firing holds the priority of each NodeConditionType of the firing alerts
+	firing map[corev1.NodeConditionType]int
+}
+
+func (n *nodeStatusReconciler) updateNodeStatuses(ctx context.Context, log logr.Logger, node *corev1.Node) error {
+	silences := n.readLocalSilences(log, node, time.Now())
+	n.setLocalSilences(node.Name, len(silences))
+
+	// read ahead of the alerts, a sync in between only delays counting it
+	synced := v1.NewTime(n.alertCache.SyncedAt())
+	byRule := make([]*ruleAlerts, 0, len(n.rules))
+	var skipped []skippedAlert
+	for _, rule := range n.rules {
+		alerts, currentTime, fetchErr := n.alertCache.Get(node, rule.Name)
+		var evalErr *alert.EvaluationError
+		if errors.As(fetchErr, &evalErr) {
+			// the alerts that could be evaluated are still complete
+			for _, failure := range evalErr.Failures {
+				skipped = append(skipped, n.skipAlert(log, rule, failure.Alert, invalidReasonEvaluation, failure.Err))
 			}
-			existing, ok := incomingConditions[condAndPriority.condition.Type]
-			// only overwrite if new condition is of higher priority
-			if !ok || existing.priority > condAndPriority.priority {
-				incomingConditions[condAndPriority.condition.Type] = condAndPriority
+			fetchErr = nil
+		}
+		ra := &ruleAlerts{
+			rule:       rule,
+			current:    v1.NewTime(currentTime),
+			synced:     synced,
+			fetchErr:   fetchErr,
+			incoming:   make(map[corev1.NodeConditionType]*conditionAndPriority, len(alerts)),
+			suppressed: make(map[corev1.NodeConditionType]*conditionAndPriority),
+		}
+		// only if we have valid results (no err) will we need converted conditions
+		if fetchErr == nil {
+			for _, al := range alerts {
+				condAndPriority, err := convertAlertToCondition(al, ra.current, rule, n.priority(log, al))
+				if err != nil {
+					skipped = append(skipped, n.skipAlert(log, rule, al, invalidReasonConversion, err))
+					continue
+				}
+				if silence := silences.match(al); silence != nil {
+					ra.suppress(condAndPriority, statusFalse, reasonSilencedOnNode, silence.message())
+					continue
+				}
+				if reason := suppressedReason(al); reason != "" {
+					if n.suppressedStatus != statusTrue {
+						ra.suppress(condAndPriority, corev1.ConditionStatus(n.suppressedStatus), reason, condAndPriority.condition.Message)
+						continue
+					}
+					condAndPriority.condition.Reason = reason
+				}
+				existing, ok := ra.incoming[condAndPriority.condition.Type]
+				// only overwrite if new condition is of higher priority
+				if !ok || existing.priority > condAndPriority.priority {
+					ra.incoming[condAndPriority.condition.Type] = condAndPriority
+				}
 			}
 		}
+		ra.firing = make(map[corev1.NodeConditionType]int, len(ra.incoming))
+		for conditionType, incoming := range ra.incoming {
+			ra.firing[conditionType] = incoming.priority
+			// an alert that is not suppressed keeps the condition firing
+			delete(ra.suppressed, conditionType)
+		}
+		byRule = append(byRule, ra)
+	}
+	n.recordInvalidAlerts(node, byRule, skipped)
+
+	// damping carries the progress of conditions towards a status change between
+	// reconciles. Conditions whose progress was observed are in observed, and those
+	// still held back are carried over to the next reconcile in states.
+	previousStates := readDampingStates(log, node)
+	states := make(dampingStates)
+	observed := make(map[corev1.NodeConditionType]bool)
+	stateOf := func(conditionType corev1.NodeConditionType) *dampingState {
+		observed[conditionType] = true
+		if state, ok := previousStates[conditionType]; ok {
+			return state
+		}
+		return &dampingState{}
 	}
 
 	nonDeletedConditions := make([]corev1.NodeCondition, 0, len(node.Status.Conditions))
 	for i := range node.Status.Conditions {
 		existing := &node.Status.Conditions[i]
-		if !strings.HasPrefix(string(existing.Type), n.conditionPrefix) {
+		ra := owner(byRule, existing.Type)
+		if ra == nil {
 			nonDeletedConditions = append(nonDeletedConditions, *existing)
 			continue
 		}
+		current, fetchErr, incomingConditions := ra.current, ra.fetchErr, ra.incoming
 
-		condLog := log.WithValues("condition", existing.Type, "oldStatus", existing.Status)
+		condLog := log.WithValues("condition", existing.Type, "oldStatus", existing.Status, "rule", ra.rule.Name)
 		updatedAndPriority, updateExists := incomingConditions[existing.Type]
 
-		// fetchErr present - mark conditions as Unknown
+		// fetchErr present - mark conditions as Unknown, or keep them
+		if fetchErr != nil && n.fetchErrorPolicy == FetchErrorKeep {
+			nonDeletedConditions = append(nonDeletedConditions, *existing)
+			continue
+		}
 		if fetchErr != nil {
+			state := stateOf(existing.Type)
 			if existing.Status != statusUnknown {
+				state.WasTrue = existing.Status == statusTrue
 				existing.LastTransitionTime = current
 				n.updateStatusCounter.WithLabelValues(string(existing.Status), statusUnknown).Inc()
 				condLog.WithValues("newStatus", statusUnknown).Info("updating existing condition with new status")
 				existing.Status = statusUnknown
+				n.recorder.Eventf(node, corev1.EventTypeWarning, eventReasonConditionUnknown,
+					"%s is Unknown as alerts are unavailable: %v", existing.Type, fetchErr)
 			}
 			existing.Reason = reasonUnavailable
-			existing.Message = ""
+			existing.Message = fetchErr.Error()
 			existing.LastHeartbeatTime = current
 			nonDeletedConditions = append(nonDeletedConditions, *existing)
+			if *state != (dampingState{}) {
+				states[existing.Type] = state
+			}
+			continue
+		}
+
+		// alert is suppressed - update status straight away
+		if suppressed, ok := ra.suppressed[existing.Type]; ok {
+			observed[existing.Type] = true
+			updated := suppressed.condition
+			if existing.Status != updated.Status {
+				existing.LastTransitionTime = current
+				n.updateStatusCounter.WithLabelValues(string(existing.Status), string(updated.Status)).Inc()
+				condLog.WithValues("newStatus", updated.Status, "reason", updated.Reason).Info("updating suppressed condition with new status")
+				existing.Status = updated.Status
+				n.recorder.Event(node, corev1.EventTypeNormal, eventReasonConditionSuppressed, suppressed.suppressedMessage())
+			}
+			existing.Reason = updated.Reason
+			existing.Message = updated.Message
+			existing.LastHeartbeatTime = current
+			nonDeletedConditions = append(nonDeletedConditions, *existing)
+			delete(ra.suppressed, existing.Type)
 			continue
 		}
 
 		// alert is present - update accordingly
 		if updateExists {
+			state := stateOf(existing.Type)
+			if existing.Status == statusTrue && state.AbsentSince != nil {
+				n.dampedTransitions.WithLabelValues(statusFalse).Inc()
+				condLog.Info("alert fired again before the condition resolved")
+			}
+			// conditions become True from Unknown as from False, so that a fetch error
+			// does not skip damping, unless they were True before it
+			wasTrue := existing.Status == statusTrue || existing.Status == statusUnknown && state.WasTrue
+			if !wasTrue && !n.damping.firing(state, ra.synced, current, updatedAndPriority.activeAt) {
+				condLog.Info("holding back firing condition", "observations", state.Firing)
+				states[existing.Type] = state
+				existing.LastHeartbeatTime = current
+				nonDeletedConditions = append(nonDeletedConditions, *existing)
+				incomingConditions[existing.Type] = nil
+				continue
+			}
+			if !wasTrue && !n.admit(ctx, condLog, node, existing.Type) {
+				existing.LastHeartbeatTime = current
+				nonDeletedConditions = append(nonDeletedConditions, *existing)
+				incomingConditions[existing.Type] = nil
+				continue
+			}
 			updated := updatedAndPriority.condition
 			existing.LastHeartbeatTime = updated.LastHeartbeatTime
 			existing.Message = updated.Message
@@ -187,25 +652,46 @@ func (n *nodeStatusReconciler) updateNodeStatuses(log logr.Logger, node *corev1.
 				condLog.WithValues("newStatus", updated.Status).Info("updating existing condition with new status")
 				existing.Status = updated.Status
 				existing.LastTransitionTime = updated.LastTransitionTime
+				n.recorder.Event(node, corev1.EventTypeWarning, eventReasonConditionFiring, updatedAndPriority.firingMessage())
 			}
 			nonDeletedConditions = append(nonDeletedConditions, *existing)
 			incomingConditions[existing.Type] = nil
 			continue
 		}
 		// else alert is not present - set status to false (or delete)
+		state := stateOf(existing.Type)
+		if existing.Status == statusTrue && !n.damping.resolved(state, current) {
+			condLog.Info("holding back resolved condition", "absentSince", state.AbsentSince)
+			states[existing.Type] = state
+			existing.LastHeartbeatTime = current
+			nonDeletedConditions = append(nonDeletedConditions, *existing)
+			continue
+		}
+		if existing.Status == statusFalse && state.Firing > 0 {
+			n.dampedTransitions.WithLabelValues(statusTrue).Inc()
+			condLog.Info("alert resolved before the condition fired")
+		}
 		if existing.Status != statusFalse {
 			existing.LastTransitionTime = current
 			n.updateStatusCounter.WithLabelValues(string(existing.Status), statusFalse).Inc()
 			condLog.WithValues("newStatus", statusFalse).Info("updating existing condition with new status")
 			existing.Status = statusFalse
+			if existing.Message != "" {
+				n.recorder.Eventf(node, corev1.EventTypeNormal, eventReasonConditionResolved,
+					"%s resolved, was: %s", existing.Type, existing.Message)
+			} else {
+				n.recorder.Eventf(node, corev1.EventTypeNormal, eventReasonConditionResolved, "%s resolved", existing.Type)
+			}
 		}
 		existing.Reason = reasonNotFiring
 		existing.Message = ""
 		existing.LastHeartbeatTime = current
 		if n.linger != 0 {
-			if shouldDelete(existing, n.linger, current, n.conditionPrefix) {
+			if shouldDelete(existing, n.linger, current, ra.rule.ConditionPrefix) {
 				n.updateStatusCounter.WithLabelValues(string(existing.Status), "").Inc()
 				condLog.Info("deleting lingering condition")
+				n.recorder.Eventf(node, corev1.EventTypeNormal, eventReasonConditionDeleted,
+					"Deleted %s after it was resolved for longer than %s", existing.Type, n.linger)
 				continue
 			}
 		}
@@ -213,19 +699,96 @@ func (n *nodeStatusReconciler) updateNodeStatuses(log logr.Logger, node *corev1.
 	}
 
 	// for any remaining incoming conditions we haven't yet seen on the current conditions, append
-	for _, incomingCondAndPriority := range incomingConditions {
-		if incomingCondAndPriority == nil {
+	for _, ra := range byRule {
+		for _, incomingCondAndPriority := range ra.incoming {
+			if incomingCondAndPriority == nil {
+				continue
+			}
+			incomingCondition := incomingCondAndPriority.condition
+			condLog := log.WithValues("condition", incomingCondition.Type, "newStatus", incomingCondition.Status, "rule", ra.rule.Name)
+			state := stateOf(incomingCondition.Type)
+			if !n.damping.firing(state, ra.synced, ra.current, incomingCondAndPriority.activeAt) {
+				condLog.Info("holding back new condition", "observations", state.Firing)
+				states[incomingCondition.Type] = state
+				continue
+			}
+			if !n.admit(ctx, condLog, node, incomingCondition.Type) {
+				continue
+			}
+			n.updateStatusCounter.WithLabelValues("", string(incomingCondition.Status)).Inc()
+			condLog.Info("adding new condition")
+			n.recorder.Event(node, corev1.EventTypeWarning, eventReasonConditionAdded, incomingCondAndPriority.firingMessage())
+			nonDeletedConditions = append(nonDeletedConditions, *incomingCondition)
+		}
+		for _, suppressed := range ra.suppressed {
+			condition := suppressed.condition
+			observed[condition.Type] = true
+			n.updateStatusCounter.WithLabelValues("", string(condition.Status)).Inc()
+			log.Info("adding suppressed condition", "condition", condition.Type, "newStatus", condition.Status, "reason", condition.Reason, "rule", ra.rule.Name)
+			n.recorder.Event(node, corev1.EventTypeNormal, eventReasonConditionSuppressed, suppressed.suppressedMessage())
+			nonDeletedConditions = append(nonDeletedConditions, *condition)
+		}
+	}
+
+	// the alerts of held back conditions that are neither on the node nor firing
+	// resolved, unless they could not be fetched
+	for conditionType, state := range previousStates {
+		if observed[conditionType] {
 			continue
 		}
-		incomingCondition := incomingCondAndPriority.condition
-		n.updateStatusCounter.WithLabelValues("", string(incomingCondition.Status)).Inc()
-		condLog := log.WithValues("condition", incomingCondition.Type, "newStatus", incomingCondition.Status)
-		condLog.Info("adding new condition")
-		nonDeletedConditions = append(nonDeletedConditions, *incomingCondition)
+		ra := owner(byRule, conditionType)
+		if ra == nil {
+			continue
+		}
+		if ra.fetchErr != nil {
+			states[conditionType] = state
+			continue
+		}
+		if state.Firing > 0 {
+			n.dampedTransitions.WithLabelValues(statusTrue).Inc()
+			log.Info("alert resolved before the condition fired", "condition", conditionType, "rule", ra.rule.Name)
+		}
 	}
 
 	node.Status.Conditions = nonDeletedConditions
+	updateTaints(log, node, byRule)
+	if n.drainer != nil {
+		n.drainer.update(ctx, log, node, byRule)
+	}
+
+	return writeDampingStates(node, states)
+}
+
+// suppress keeps the NodeCondition of cp apart with status, reason and message, unless
+// another alert keeps it firing
+func (ra *ruleAlerts) suppress(cp *conditionAndPriority, status corev1.ConditionStatus, reason, message string) {
+	cp.condition.Status = status
+	cp.condition.Reason = reason
+	cp.condition.Message = message
+	ra.suppressed[cp.condition.Type] = cp
+}
 
+// suppressedReason returns the reason for the NodeCondition of an alert that is
+// suppressed in Alertmanager, or an empty reason if it is not
+func suppressedReason(al alert.Alert) string {
+	switch {
+	case al.Silenced():
+		return reasonSilenced
+	case al.Inhibited():
+		return reasonInhibited
+	default:
+		return ""
+	}
+}
+
+// owner returns the rule owning conditions of conditionType, or nil if the
+// condition is not owned
+func owner(byRule []*ruleAlerts, conditionType corev1.NodeConditionType) *ruleAlerts {
+	for _, ra := range byRule {
+		if strings.HasPrefix(string(conditionType), ra.rule.ConditionPrefix) {
+			return ra
+		}
+	}
 	return nil
 }
 
@@ -238,39 +801,83 @@ func shouldDelete(condition *corev1.NodeCondition, linger time.Duration, current
 type conditionAndPriority struct {
 	condition *corev1.NodeCondition
 	priority  int
+	activeAt  time.Time
+	summary   string
 }
 
-func convertAlertToCondition(olog logr.Logger, al promv1.Alert, currentTime v1.Time, conditionPrefix string) (*conditionAndPriority, error) {
-	alertname := al.Labels[alertNameLabel]
-	if alertname == "" {
-		return nil, errors.New("no alertname label")
+// suppressedMessage describes the suppressed alert of the condition for an Event
+func (c *conditionAndPriority) suppressedMessage() string {
+	return fmt.Sprintf("%s is %s as %s: %s", c.condition.Type, c.condition.Status, c.condition.Reason, c.condition.Message)
+}
+
+// firingMessage describes the firing alert of the condition for an Event
+func (c *conditionAndPriority) firingMessage() string {
+	if c.summary == "" {
+		return fmt.Sprintf("%s is firing with priority %d", c.condition.Type, c.priority)
+	}
+	return fmt.Sprintf("%s is firing with priority %d: %s", c.condition.Type, c.priority, c.summary)
+}
+
+// admit returns true if conditionType may become True on node under the blast radius guard
+func (n *nodeStatusReconciler) admit(ctx context.Context, log logr.Logger, node *corev1.Node, conditionType corev1.NodeConditionType) bool {
+	if n.guard == nil {
+		return true
+	}
+	ok, limit := n.guard.admit(ctx, conditionType, node.Name)
+	if !ok {
+		log.Info("holding back condition that would become True on too many nodes", "limit", limit)
+		n.recorder.Eventf(node, corev1.EventTypeWarning, eventReasonBlastRadius,
+			"Held back %s from becoming True as it would on more than %d nodes", conditionType, limit)
 	}
+	return ok
+}
+
+// skipAlert counts and logs an alert that could not be turned into a NodeCondition
+func (n *nodeStatusReconciler) skipAlert(log logr.Logger, rule Rule, al alert.Alert, reason string, err error) skippedAlert {
+	n.invalidAlerts.WithLabelValues(rule.Name, reason).Inc()
+	log.Error(err, "skipping invalid alert", "rule", rule.Name, "labels", al.Labels)
+	return newSkippedAlert(rule, al, reason, err)
+}
+
+// priority returns the priority of al, falling back to the default priority
+// when it is missing or malformed
+func (n *nodeStatusReconciler) priority(olog logr.Logger, al alert.Alert) int {
+	alertname := al.Labels[alertNameLabel]
 	log := olog.WithValues("alertname", alertname)
-	priority := defaultPriority
-	rawPriority, ok := al.Labels[alertPriorityLabel]
-	if ok {
-		parsed, err := strconv.Atoi(string(rawPriority))
-		if err != nil {
-			return nil, errors.New("malformed alert priority")
-		}
-		priority = parsed
-	} else {
+	priority, labeled, err := n.priorities.of(al)
+	if err != nil {
+		n.invalidPriorities.WithLabelValues(string(alertname)).Inc()
+		log.Error(err, "using default priority", "priority", priority)
+	} else if !labeled {
 		log.Info("No priority label present, using default priority")
 	}
-	message := fmt.Sprintf("[P%d]", priority)
-	if summary, ok := al.Annotations[alertSummaryAnnotation]; ok {
-		message = message + " " + string(summary)
+	return priority
+}
+
+func convertAlertToCondition(al alert.Alert, currentTime v1.Time, rule Rule, priority int) (*conditionAndPriority, error) {
+	if al.Labels[alertNameLabel] == "" {
+		return nil, errors.New("no alertname label")
+	}
+	templates := rule.Templates
+	if templates == nil {
+		templates = defaultTemplates
+	}
+	conditionType, reason, message, err := templates.render(al, rule.ConditionPrefix, priority)
+	if err != nil {
+		return nil, err
 	}
 	condition := &corev1.NodeCondition{
-		Type:               corev1.NodeConditionType(fmt.Sprintf("%s%s", conditionPrefix, alertname)),
+		Type:               conditionType,
 		Status:             statusTrue,
 		LastHeartbeatTime:  currentTime,
 		LastTransitionTime: currentTime,
-		Reason:             reasonFiring,
+		Reason:             reason,
 		Message:            message,
 	}
 	return &conditionAndPriority{
 		condition: condition,
 		priority:  priority,
+		activeAt:  al.ActiveAt,
+		summary:   string(al.Annotations[summaryAnnotation]),
 	}, nil
 }
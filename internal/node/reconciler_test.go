@@ -13,6 +13,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/mock"
 	"gotest.tools/v3/assert"
@@ -21,7 +22,10 @@ import (
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -33,15 +37,47 @@ var (
 
 func Test_Reconcile(t *testing.T) {
 	const (
-		resyncInterval  = 2 * time.Minute
-		conditionPrefix = "AlertManager_"
+		resyncInterval    = 2 * time.Minute
+		heartbeatInterval = 10 * time.Minute
+		conditionPrefix   = "AlertManager_"
 	)
+	firing := func(priority model.LabelValue) []alert.Alert {
+		return []alert.Alert{
+			{Alert: promv1.Alert{
+				State: promv1.AlertStateFiring,
+				Annotations: model.LabelSet{
+					"summary": "Node has erupted into fire at 500C",
+				},
+				Labels: model.LabelSet{
+					"alertname": "NodeOnFire",
+					"priority":  priority,
+				},
+			}},
+		}
+	}
+	nodeOnFire := func(heartbeat v1.Time) *corev1.Node {
+		return newNode(
+			corev1.NodeCondition{
+				Type:   "Ready",
+				Status: "True",
+			},
+			corev1.NodeCondition{
+				Status:             "True",
+				Type:               "AlertManager_NodeOnFire",
+				Reason:             "AlertIsFiring",
+				Message:            "[P3] Node has erupted into fire at 500C",
+				LastHeartbeatTime:  heartbeat,
+				LastTransitionTime: oldTime,
+			},
+		)
+	}
 	tests := []struct {
 		name        string
 		node        *corev1.Node
 		expected    *corev1.Node
 		updateMocks func(cache *mockAlertCache)
 		want        reconcile.Result
+		wantPatch   string
 		wantErr     bool
 	}{
 		{
@@ -81,9 +117,9 @@ func Test_Reconcile(t *testing.T) {
 				},
 			},
 			updateMocks: func(cache *mockAlertCache) {
-				cache.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				cache.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -91,14 +127,15 @@ func Test_Reconcile(t *testing.T) {
 							Labels: model.LabelSet{
 								"alertname": "NodeOnFire",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
 				)
 			},
-			want:    reconcile.Result{RequeueAfter: resyncInterval},
-			wantErr: false,
+			want:      reconcile.Result{RequeueAfter: resyncInterval},
+			wantPatch: patchWritten,
+			wantErr:   false,
 		},
 		{
 			name: "no update",
@@ -145,9 +182,9 @@ func Test_Reconcile(t *testing.T) {
 				},
 			},
 			updateMocks: func(cache *mockAlertCache) {
-				cache.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				cache.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -156,7 +193,7 @@ func Test_Reconcile(t *testing.T) {
 								"alertname": "NodeOnFire",
 								"priority":  "3",
 							},
-						},
+						}},
 					},
 					oldTime.Time,
 					nil,
@@ -165,6 +202,26 @@ func Test_Reconcile(t *testing.T) {
 			want:    reconcile.Result{RequeueAfter: resyncInterval},
 			wantErr: false,
 		},
+		{
+			name:     "heartbeat within interval",
+			node:     nodeOnFire(oldTime),
+			expected: nodeOnFire(oldTime),
+			updateMocks: func(cache *mockAlertCache) {
+				cache.On("Get", "node1", "default").Return(firing("3"), oldTime.Add(heartbeatInterval-time.Second), nil)
+			},
+			want:      reconcile.Result{RequeueAfter: resyncInterval},
+			wantPatch: patchSkipped,
+		},
+		{
+			name:     "heartbeat past interval",
+			node:     nodeOnFire(oldTime),
+			expected: nodeOnFire(currentTime),
+			updateMocks: func(cache *mockAlertCache) {
+				cache.On("Get", "node1", "default").Return(firing("3"), currentTime.Time, nil)
+			},
+			want:      reconcile.Result{RequeueAfter: resyncInterval},
+			wantPatch: patchWritten,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -180,7 +237,7 @@ func Test_Reconcile(t *testing.T) {
 				Build()
 			ac := &mockAlertCache{}
 			tt.updateMocks(ac)
-			n := NewNodeStatusReconciler(c, logr.Discard(), prometheus.NewRegistry(), resyncInterval, time.Minute, time.Minute, ac, conditionPrefix)
+			n := NewNodeStatusReconciler(c, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), ac, ReconcilerOptions{ResyncInterval: resyncInterval, ReconcileTimeout: time.Minute, Linger: time.Minute, HeartbeatInterval: heartbeatInterval, Rules: []Rule{{Name: "default", ConditionPrefix: conditionPrefix}}})
 			got, err := n.Reconcile(context.Background(), request)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
@@ -190,6 +247,13 @@ func Test_Reconcile(t *testing.T) {
 				t.Errorf("Reconcile() got = %v, want %v", got, tt.want)
 			}
 			mock.AssertExpectationsForObjects(t, ac)
+			for _, result := range []string{patchWritten, patchSkipped} {
+				want := 0.0
+				if result == tt.wantPatch {
+					want = 1
+				}
+				assert.Equal(t, want, testutil.ToFloat64(n.(*nodeStatusReconciler).statusPatches.WithLabelValues(result)), result)
+			}
 			actual := &corev1.Node{}
 			assert.NilError(t, c.Get(context.TODO(), types.NamespacedName{Name: tt.expected.Name}, actual))
 			assert.DeepEqual(t, tt.expected, actual,
@@ -229,9 +293,9 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -239,7 +303,7 @@ func Test_updateNodeStatuses(t *testing.T) {
 							Labels: model.LabelSet{
 								"alertname": "NodeOnFire",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
@@ -267,9 +331,9 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -278,7 +342,7 @@ func Test_updateNodeStatuses(t *testing.T) {
 								"alertname": "NodeOnFire",
 								"priority":  "5",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
@@ -306,9 +370,9 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -317,8 +381,8 @@ func Test_updateNodeStatuses(t *testing.T) {
 								"alertname": "NodeOnFire",
 								"priority":  "5",
 							},
-						},
-						{
+						}},
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -327,7 +391,7 @@ func Test_updateNodeStatuses(t *testing.T) {
 								"alertname": "NodeOnFire",
 								"priority":  "6",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
@@ -355,9 +419,9 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -366,8 +430,8 @@ func Test_updateNodeStatuses(t *testing.T) {
 								"alertname": "NodeOnFire",
 								"priority":  "6",
 							},
-						},
-						{
+						}},
+						{Alert: promv1.Alert{
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
 							},
@@ -375,7 +439,7 @@ func Test_updateNodeStatuses(t *testing.T) {
 								"alertname": "NodeOnFire",
 								"priority":  "5",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
@@ -383,15 +447,29 @@ func Test_updateNodeStatuses(t *testing.T) {
 			},
 		},
 		{
-			name: "missing alertname label",
+			name: "malformed priority label falls back to default priority",
 			node: newNode(corev1.NodeCondition{
 				Status: "True",
 				Type:   "Ready",
 			}),
+			expected: newNode(
+				corev1.NodeCondition{
+					Status: "True",
+					Type:   "Ready",
+				},
+				corev1.NodeCondition{
+					Status:             "True",
+					Type:               "AlertManager_NodeOnFire",
+					Reason:             "AlertIsFiring",
+					Message:            "[P9] Node has erupted into fire at 500C",
+					LastHeartbeatTime:  currentTime,
+					LastTransitionTime: currentTime,
+				},
+			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -400,24 +478,23 @@ func Test_updateNodeStatuses(t *testing.T) {
 								"alertname": "NodeOnFire",
 								"priority":  "blah",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
 				)
 			},
-			wantErr: true,
 		},
 		{
-			name: "malformed priority label",
+			name: "missing alertname label",
 			node: newNode(corev1.NodeCondition{
 				Status: "True",
 				Type:   "Ready",
 			}),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -425,13 +502,16 @@ func Test_updateNodeStatuses(t *testing.T) {
 							Labels: model.LabelSet{
 								"": "othervalue",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
 				)
 			},
-			wantErr: true,
+			expected: newNode(corev1.NodeCondition{
+				Status: "True",
+				Type:   "Ready",
+			}),
 		},
 		{
 			name: "missing summary annotation",
@@ -454,9 +534,9 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"description": "Node has erupted into fire at 500C",
@@ -464,7 +544,7 @@ func Test_updateNodeStatuses(t *testing.T) {
 							Labels: model.LabelSet{
 								"alertname": "NodeOnFire",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
@@ -482,7 +562,7 @@ func Test_updateNodeStatuses(t *testing.T) {
 				Type:   "Ready",
 			}),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
+				client.On("Get", "node1", "default").Return(
 					nil,
 					currentTime.Time,
 					errors.New("cannot get alerts"),
@@ -505,22 +585,27 @@ func Test_updateNodeStatuses(t *testing.T) {
 					LastTransitionTime: oldTime,
 				},
 			),
-			expected: newNode(
-				corev1.NodeCondition{
-					Status: "True",
-					Type:   "Ready",
-				},
-				corev1.NodeCondition{
-					Status:             "Unknown",
-					Type:               "AlertManager_NodeOnFire",
-					Reason:             "AlertsUnavailable",
-					Message:            "",
-					LastHeartbeatTime:  currentTime,
-					LastTransitionTime: currentTime,
-				},
-			),
+			expected: func() *corev1.Node {
+				node := newNode(
+					corev1.NodeCondition{
+						Status: "True",
+						Type:   "Ready",
+					},
+					corev1.NodeCondition{
+						Status:             "Unknown",
+						Type:               "AlertManager_NodeOnFire",
+						Reason:             "AlertsUnavailable",
+						Message:            "cannot get alerts",
+						LastHeartbeatTime:  currentTime,
+						LastTransitionTime: currentTime,
+					},
+				)
+				// the condition was True before its alerts became unavailable
+				node.Annotations = map[string]string{dampingAnnotation: `{"AlertManager_NodeOnFire":{"wasTrue":true}}`}
+				return node
+			}(),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
+				client.On("Get", "node1", "default").Return(
 					nil,
 					currentTime.Time,
 					errors.New("cannot get alerts"),
@@ -562,9 +647,9 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -572,7 +657,7 @@ func Test_updateNodeStatuses(t *testing.T) {
 							Labels: model.LabelSet{
 								"alertname": "NodeOnFire",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
@@ -614,9 +699,9 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{
-						{
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{
+						{Alert: promv1.Alert{
 							State: promv1.AlertStateFiring,
 							Annotations: model.LabelSet{
 								"summary": "Node has erupted into fire at 500C",
@@ -625,7 +710,7 @@ func Test_updateNodeStatuses(t *testing.T) {
 								"alertname": "NodeOnFire",
 								"priority":  "7",
 							},
-						},
+						}},
 					},
 					currentTime.Time,
 					nil,
@@ -663,8 +748,8 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{},
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{},
 					currentTime.Time,
 					nil,
 				)
@@ -700,8 +785,8 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{},
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{},
 					currentTime.Time,
 					nil,
 				)
@@ -727,8 +812,8 @@ func Test_updateNodeStatuses(t *testing.T) {
 				Type:   "Ready",
 			}),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{},
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{},
 					currentTime.Time,
 					nil,
 				)
@@ -754,8 +839,8 @@ func Test_updateNodeStatuses(t *testing.T) {
 				Type:   "Ready",
 			}),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{},
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{},
 					currentTime.Time,
 					nil,
 				)
@@ -803,8 +888,8 @@ func Test_updateNodeStatuses(t *testing.T) {
 				},
 			),
 			updateMock: func(client *mockAlertCache) {
-				client.On("Get", "node1").Return(
-					[]promv1.Alert{},
+				client.On("Get", "node1", "default").Return(
+					[]alert.Alert{},
 					currentTime.Time,
 					nil,
 				)
@@ -829,9 +914,17 @@ func Test_updateNodeStatuses(t *testing.T) {
 				updateStatusCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
 					Name: "test",
 				}, []string{"old_status", "new_status"}),
-				conditionPrefix: conditionPrefix,
+				invalidPriorities: prometheus.NewCounterVec(prometheus.CounterOpts{
+					Name: "test_invalid_priorities",
+				}, []string{"alertname"}),
+				invalidAlerts: prometheus.NewCounterVec(prometheus.CounterOpts{
+					Name: "test_invalid_alerts",
+				}, []string{"rule", "reason"}),
+				recorder:   record.NewFakeRecorder(10),
+				rules:      []Rule{{Name: "default", ConditionPrefix: conditionPrefix}},
+				priorities: DefaultPriorities,
 			}
-			if err := r.updateNodeStatuses(logr.Discard(), tt.node); (err != nil) != tt.wantErr {
+			if err := r.updateNodeStatuses(context.Background(), logr.Discard(), tt.node); (err != nil) != tt.wantErr {
 				t.Errorf("updateNodeStatuses() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if !tt.wantErr && !equality.Semantic.DeepEqual(tt.expected, tt.node) {
@@ -842,22 +935,127 @@ func Test_updateNodeStatuses(t *testing.T) {
 	}
 }
 
+func Test_Reconcile_concurrentWrite(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NilError(t, corev1.AddToScheme(scheme))
+	node := newNode(
+		corev1.NodeCondition{Type: "Ready", Status: "True", LastHeartbeatTime: oldTime},
+		corev1.NodeCondition{
+			Type:               "AlertManager_NodeOnFire",
+			Status:             "True",
+			Reason:             "AlertIsFiring",
+			Message:            "[P9]",
+			LastHeartbeatTime:  oldTime,
+			LastTransitionTime: oldTime,
+		},
+	)
+
+	// the kubelet updates its conditions between our Get and Patch of the first attempt
+	var patches int
+	c := fake.NewClientBuilder().
+		WithRuntimeObjects(node).
+		WithScheme(scheme).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourcePatch: func(ctx context.Context, c client.Client, subResource string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+				patches++
+				if patches == 1 {
+					kubelet := &corev1.Node{}
+					assert.NilError(t, c.Get(ctx, types.NamespacedName{Name: "node1"}, kubelet))
+					kubelet.Status.Conditions[0].LastHeartbeatTime = currentTime
+					kubelet.Status.Conditions = append(kubelet.Status.Conditions,
+						corev1.NodeCondition{Type: "MemoryPressure", Status: "False", LastHeartbeatTime: currentTime})
+					assert.NilError(t, c.Status().Update(ctx, kubelet))
+				}
+				return c.SubResource(subResource).Patch(ctx, obj, patch, opts...)
+			},
+		}).
+		Build()
+	ac := &mockAlertCache{}
+	ac.On("Get", "node1", "default").Return([]alert.Alert{}, currentTime.Time, nil)
+	n := NewNodeStatusReconciler(c, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), ac, ReconcilerOptions{ResyncInterval: time.Minute, ReconcileTimeout: time.Minute, Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}})
+
+	_, err := n.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "node1"}})
+	assert.NilError(t, err)
+	assert.Equal(t, 2, patches)
+
+	expected := newNode(
+		corev1.NodeCondition{Type: "Ready", Status: "True", LastHeartbeatTime: currentTime},
+		corev1.NodeCondition{
+			Type:               "AlertManager_NodeOnFire",
+			Status:             "False",
+			Reason:             "AlertIsNotFiring",
+			LastHeartbeatTime:  currentTime,
+			LastTransitionTime: currentTime,
+		},
+		corev1.NodeCondition{Type: "MemoryPressure", Status: "False", LastHeartbeatTime: currentTime},
+	)
+	actual := &corev1.Node{}
+	assert.NilError(t, c.Get(context.Background(), types.NamespacedName{Name: "node1"}, actual))
+	assert.DeepEqual(t, expected, actual,
+		cmpopts.IgnoreFields(v1.ObjectMeta{}, "ResourceVersion"),
+		cmpopts.IgnoreTypes(v1.TypeMeta{}))
+}
+
 type mockAlertCache struct {
 	mock.Mock
+	// synced is returned by SyncedAt
+	synced time.Time
 }
 
-func (m *mockAlertCache) Get(nodeName string) ([]promv1.Alert, time.Time, error) {
-	args := m.Called(nodeName)
+func (m *mockAlertCache) Get(node *corev1.Node, rule string) ([]alert.Alert, time.Time, error) {
+	args := m.Called(node.Name, rule)
 	alerts := args.Get(0)
 	someTime := args.Get(1).(time.Time)
 	if alerts == nil {
 		return nil, someTime, args.Error(2)
 	}
-	return args.Get(0).([]promv1.Alert), someTime, args.Error(2)
+	return args.Get(0).([]alert.Alert), someTime, args.Error(2)
+}
+
+func (m *mockAlertCache) SyncedAt() time.Time {
+	return m.synced
 }
 
 var _ alert.Cache = &mockAlertCache{}
 
+func Test_Reconcile_taints(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NilError(t, corev1.AddToScheme(scheme))
+	node := newNode(corev1.NodeCondition{
+		Type:               "AlertManager_NodeFlooded",
+		Status:             "True",
+		Reason:             "AlertIsFiring",
+		Message:            "[P1]",
+		LastHeartbeatTime:  oldTime,
+		LastTransitionTime: oldTime,
+	})
+	node.Spec.Taints = []corev1.Taint{
+		{Key: "node.kubernetes.io/unschedulable", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "sciuro.cloudflare.com/AlertManager_NodeFlooded", Effect: corev1.TaintEffectNoSchedule},
+	}
+	c := fake.NewClientBuilder().
+		WithRuntimeObjects(node).
+		WithScheme(scheme).
+		Build()
+	ac := &mockAlertCache{}
+	ac.On("Get", "node1", "default").Return([]alert.Alert{{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+	}}}, currentTime.Time, nil)
+	n := NewNodeStatusReconciler(c, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), ac, ReconcilerOptions{ResyncInterval: time.Minute, ReconcileTimeout: time.Minute, Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_", Taints: Taints{corev1.TaintEffectNoSchedule: 2}}}})
+
+	_, err := n.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "node1"}})
+	assert.NilError(t, err)
+
+	actual := &corev1.Node{}
+	assert.NilError(t, c.Get(context.Background(), types.NamespacedName{Name: "node1"}, actual))
+	assert.DeepEqual(t, []corev1.Taint{
+		{Key: "node.kubernetes.io/unschedulable", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectNoSchedule},
+	}, actual.Spec.Taints)
+	assert.Equal(t, corev1.ConditionFalse, actual.Status.Conditions[0].Status)
+}
+
 func newNode(conditions ...corev1.NodeCondition) *corev1.Node {
 	return &corev1.Node{
 		ObjectMeta: v1.ObjectMeta{
@@ -868,3 +1066,418 @@ func newNode(conditions ...corev1.NodeCondition) *corev1.Node {
 		},
 	}
 }
+
+func Test_updateNodeStatuses_rules(t *testing.T) {
+	rules := []Rule{
+		{Name: "hardware", ConditionPrefix: "Hardware_"},
+		{Name: "kernel", ConditionPrefix: "Kernel_"},
+	}
+	kernelPanic := []alert.Alert{
+		{Alert: promv1.Alert{
+			State:  promv1.AlertStateFiring,
+			Labels: model.LabelSet{"alertname": "KernelPanic", "priority": "1"},
+		}},
+	}
+	tests := []struct {
+		name       string
+		updateMock func(client *mockAlertCache)
+		expected   *corev1.Node
+	}{
+		{
+			name: "each rule updates its own conditions",
+			updateMock: func(client *mockAlertCache) {
+				client.On("Get", "node1", "hardware").Return([]alert.Alert{}, currentTime.Time, nil)
+				client.On("Get", "node1", "kernel").Return(kernelPanic, currentTime.Time, nil)
+			},
+			expected: newNode(
+				corev1.NodeCondition{Type: "Ready", Status: "True"},
+				corev1.NodeCondition{
+					Type:               "Hardware_DiskFailing",
+					Status:             "False",
+					Reason:             "AlertIsNotFiring",
+					LastHeartbeatTime:  currentTime,
+					LastTransitionTime: currentTime,
+				},
+				corev1.NodeCondition{
+					Type:               "Kernel_KernelPanic",
+					Status:             "True",
+					Reason:             "AlertIsFiring",
+					Message:            "[P1]",
+					LastHeartbeatTime:  currentTime,
+					LastTransitionTime: currentTime,
+				},
+			),
+		},
+		{
+			name: "a rule that fails leaves the other rules' conditions alone",
+			updateMock: func(client *mockAlertCache) {
+				client.On("Get", "node1", "hardware").Return([]alert.Alert{}, currentTime.Time, nil)
+				client.On("Get", "node1", "kernel").Return(nil, currentTime.Time, errors.New("cel evaluation error"))
+			},
+			expected: newNode(
+				corev1.NodeCondition{Type: "Ready", Status: "True"},
+				corev1.NodeCondition{
+					Type:               "Hardware_DiskFailing",
+					Status:             "False",
+					Reason:             "AlertIsNotFiring",
+					LastHeartbeatTime:  currentTime,
+					LastTransitionTime: currentTime,
+				},
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &mockAlertCache{}
+			tt.updateMock(mockClient)
+			r := &nodeStatusReconciler{
+				log:              logr.Discard(),
+				reconcileTimeout: time.Second,
+				linger:           time.Hour * 24,
+				alertCache:       mockClient,
+				updateStatusCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+					Name: "test",
+				}, []string{"old_status", "new_status"}),
+				recorder:   record.NewFakeRecorder(10),
+				rules:      rules,
+				priorities: DefaultPriorities,
+			}
+			node := newNode(
+				corev1.NodeCondition{Type: "Ready", Status: "True"},
+				corev1.NodeCondition{
+					Type:               "Hardware_DiskFailing",
+					Status:             "True",
+					Reason:             "AlertIsFiring",
+					LastHeartbeatTime:  oldTime,
+					LastTransitionTime: oldTime,
+				},
+			)
+			assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+			if !equality.Semantic.DeepEqual(tt.expected, node) {
+				t.Errorf("updateNodeStatuses() diff = %v", cmp.Diff(tt.expected, node))
+			}
+			mock.AssertExpectationsForObjects(t, mockClient)
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		wantErr string
+	}{
+		{
+			name:  "distinct prefixes",
+			rules: []Rule{{Name: "hardware", ConditionPrefix: "Hardware_"}, {Name: "kernel", ConditionPrefix: "Kernel_"}},
+		},
+		{
+			name:    "no rules",
+			wantErr: "at least one rule is required",
+		},
+		{
+			name:    "empty prefix",
+			rules:   []Rule{{Name: "default"}},
+			wantErr: `rule "default" must have a condition prefix`,
+		},
+		{
+			name:    "invalid prefix",
+			rules:   []Rule{{Name: "default", ConditionPrefix: "Alert Manager/"}},
+			wantErr: `condition prefix "Alert Manager/" of rule "default" is not valid in a condition type`,
+		},
+		{
+			name:    "prefix starting with an underscore",
+			rules:   []Rule{{Name: "default", ConditionPrefix: "_AlertManager_"}},
+			wantErr: `condition prefix "_AlertManager_" of rule "default" is not valid in a condition type`,
+		},
+		{
+			name:    "nested prefixes",
+			rules:   []Rule{{Name: "hardware", ConditionPrefix: "Hardware_"}, {Name: "disk", ConditionPrefix: "Hardware_Disk"}},
+			wantErr: `condition prefixes of rules "hardware" and "disk" overlap`,
+		},
+		{
+			name:    "same prefix",
+			rules:   []Rule{{Name: "hardware", ConditionPrefix: "AlertManager_"}, {Name: "kernel", ConditionPrefix: "AlertManager_"}},
+			wantErr: `condition prefixes of rules "hardware" and "kernel" overlap`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRules(tt.rules)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func Test_updateNodeStatuses_invalidAlerts(t *testing.T) {
+	mockClient := &mockAlertCache{}
+	nodeOnFire := alert.Alert{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+	}}
+	unevaluable := alert.Alert{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"alertname": "NodeFlooded"},
+	}}
+	unnamed := alert.Alert{Alert: promv1.Alert{
+		State:  promv1.AlertStateFiring,
+		Labels: model.LabelSet{"instance": "node1"},
+	}}
+	mockClient.On("Get", "node1", "default").Return(
+		[]alert.Alert{nodeOnFire, unnamed},
+		currentTime.Time,
+		&alert.EvaluationError{Failures: []alert.AlertError{
+			{Alert: unevaluable, Err: errors.New("no such key: rack")},
+		}},
+	)
+	recorder := record.NewFakeRecorder(10)
+	r := NewNodeStatusReconciler(nil, logr.Discard(), prometheus.NewRegistry(), recorder, mockClient, ReconcilerOptions{Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}}).(*nodeStatusReconciler)
+
+	node := newNode(corev1.NodeCondition{Type: "Ready", Status: "True"})
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	expected := newNode(
+		corev1.NodeCondition{Type: "Ready", Status: "True"},
+		corev1.NodeCondition{
+			Type:               "AlertManager_NodeOnFire",
+			Status:             "True",
+			Reason:             "AlertIsFiring",
+			Message:            "[P1]",
+			LastHeartbeatTime:  currentTime,
+			LastTransitionTime: currentTime,
+		},
+	)
+	if !equality.Semantic.DeepEqual(expected, node) {
+		t.Errorf("updateNodeStatuses() diff = %v", cmp.Diff(expected, node))
+	}
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.invalidAlerts.WithLabelValues("default", invalidReasonEvaluation)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.invalidAlerts.WithLabelValues("default", invalidReasonConversion)))
+	assert.Equal(t, `Warning InvalidAlert Skipped alert {alertname="NodeFlooded"} of rule default: no such key: rack`, <-recorder.Events)
+	assert.Equal(t, `Warning InvalidAlert Skipped alert {instance="node1"} of rule default: no alertname label`, <-recorder.Events)
+	assert.Equal(t, `Warning ConditionAdded AlertManager_NodeOnFire is firing with priority 1`, <-recorder.Events)
+	mock.AssertExpectationsForObjects(t, mockClient)
+
+	// alerts that stay invalid are only counted again
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	assert.Equal(t, 2.0, testutil.ToFloat64(r.invalidAlerts.WithLabelValues("default", invalidReasonConversion)))
+	assert.Equal(t, 0, len(recorder.Events))
+
+	// an alert that becomes invalid again after it was valid is reported again
+	mockClient.ExpectedCalls = nil
+	mockClient.On("Get", "node1", "default").Return([]alert.Alert{nodeOnFire}, currentTime.Time, nil).Once()
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	mockClient.On("Get", "node1", "default").Return([]alert.Alert{nodeOnFire, unnamed}, currentTime.Time, nil).Once()
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	assert.Equal(t, `Warning InvalidAlert Skipped alert {instance="node1"} of rule default: no alertname label`, <-recorder.Events)
+	assert.Equal(t, 0, len(recorder.Events))
+
+	r.forgetInvalidAlerts("node1")
+	assert.Equal(t, 0, len(r.invalidByNode))
+}
+
+func Test_updateNodeStatuses_suppressed(t *testing.T) {
+	silenced := alert.Alert{
+		Alert: promv1.Alert{
+			State:  promv1.AlertStateFiring,
+			Labels: model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+		},
+		SilencedBy: []string{"4d6c4d8e"},
+	}
+	inhibited := alert.Alert{
+		Alert: promv1.Alert{
+			State:  promv1.AlertStateFiring,
+			Labels: model.LabelSet{"alertname": "NodeFlooded", "priority": "2"},
+		},
+		InhibitedBy: []string{"a1b2c3d4"},
+	}
+	condition := func(conditionType corev1.NodeConditionType, status corev1.ConditionStatus, reason, message string) corev1.NodeCondition {
+		return corev1.NodeCondition{
+			Type:               conditionType,
+			Status:             status,
+			Reason:             reason,
+			Message:            message,
+			LastHeartbeatTime:  currentTime,
+			LastTransitionTime: currentTime,
+		}
+	}
+	tests := []struct {
+		name       string
+		status     SuppressedStatus
+		want       []corev1.NodeCondition
+		wantEvents []string
+	}{
+		{
+			name:   "suppressed alerts are False",
+			status: statusFalse,
+			want: []corev1.NodeCondition{
+				condition("AlertManager_NodeOnFire", statusFalse, reasonSilenced, "[P1]"),
+				condition("AlertManager_NodeFlooded", statusFalse, reasonInhibited, "[P2]"),
+			},
+			wantEvents: []string{
+				"Normal ConditionSuppressed AlertManager_NodeOnFire is False as AlertIsSilenced: [P1]",
+				"Normal ConditionSuppressed AlertManager_NodeFlooded is False as AlertIsInhibited: [P2]",
+			},
+		},
+		{
+			name:   "suppressed alerts are Unknown",
+			status: statusUnknown,
+			want: []corev1.NodeCondition{
+				condition("AlertManager_NodeOnFire", statusUnknown, reasonSilenced, "[P1]"),
+				condition("AlertManager_NodeFlooded", statusUnknown, reasonInhibited, "[P2]"),
+			},
+		},
+		{
+			name:   "suppressed alerts keep firing",
+			status: statusTrue,
+			want: []corev1.NodeCondition{
+				{
+					Type:               "AlertManager_NodeOnFire",
+					Status:             statusTrue,
+					Reason:             reasonSilenced,
+					Message:            "[P1]",
+					LastHeartbeatTime:  currentTime,
+					LastTransitionTime: oldTime,
+				},
+				condition("AlertManager_NodeFlooded", statusTrue, reasonInhibited, "[P2]"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &mockAlertCache{}
+			mockClient.On("Get", "node1", "default").Return([]alert.Alert{silenced, inhibited}, currentTime.Time, nil)
+			recorder := record.NewFakeRecorder(10)
+			r := NewNodeStatusReconciler(nil, logr.Discard(), prometheus.NewRegistry(), recorder, mockClient, ReconcilerOptions{Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}, SuppressedStatus: tt.status}).(*nodeStatusReconciler)
+
+			// the condition of the silenced alert was firing before it was silenced
+			node := newNode(corev1.NodeCondition{
+				Type:               "AlertManager_NodeOnFire",
+				Status:             statusTrue,
+				Reason:             reasonFiring,
+				Message:            "[P1]",
+				LastHeartbeatTime:  oldTime,
+				LastTransitionTime: oldTime,
+			})
+			assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+			assert.DeepEqual(t, tt.want, node.Status.Conditions)
+			for _, want := range tt.wantEvents {
+				assert.Equal(t, want, <-recorder.Events)
+			}
+			mock.AssertExpectationsForObjects(t, mockClient)
+		})
+	}
+}
+
+func Test_updateNodeStatuses_events(t *testing.T) {
+	nodeOnFire := []alert.Alert{{Alert: promv1.Alert{
+		State:       promv1.AlertStateFiring,
+		Annotations: model.LabelSet{"summary": "Node has erupted into fire at 500C"},
+		Labels:      model.LabelSet{"alertname": "NodeOnFire", "priority": "1"},
+	}}}
+	condition := func(status corev1.ConditionStatus, message string) corev1.NodeCondition {
+		return corev1.NodeCondition{
+			Type:               "AlertManager_NodeOnFire",
+			Status:             status,
+			Message:            message,
+			LastHeartbeatTime:  oldTime,
+			LastTransitionTime: oldTime,
+		}
+	}
+	tests := []struct {
+		name       string
+		conditions []corev1.NodeCondition
+		alerts     []alert.Alert
+		fetchErr   error
+		wantEvent  string
+	}{
+		{
+			name:      "added",
+			alerts:    nodeOnFire,
+			wantEvent: "Warning ConditionAdded AlertManager_NodeOnFire is firing with priority 1: Node has erupted into fire at 500C",
+		},
+		{
+			name:       "firing",
+			conditions: []corev1.NodeCondition{condition(statusFalse, "")},
+			alerts:     nodeOnFire,
+			wantEvent:  "Warning ConditionFiring AlertManager_NodeOnFire is firing with priority 1: Node has erupted into fire at 500C",
+		},
+		{
+			name:       "resolved",
+			conditions: []corev1.NodeCondition{condition(statusTrue, "[P1] Node has erupted into fire at 500C")},
+			wantEvent:  "Normal ConditionResolved AlertManager_NodeOnFire resolved, was: [P1] Node has erupted into fire at 500C",
+		},
+		{
+			name:       "unknown",
+			conditions: []corev1.NodeCondition{condition(statusTrue, "[P1] Node has erupted into fire at 500C")},
+			fetchErr:   errors.New("alertmanager unavailable"),
+			wantEvent:  "Warning ConditionUnknown AlertManager_NodeOnFire is Unknown as alerts are unavailable: alertmanager unavailable",
+		},
+		{
+			name:       "deleted",
+			conditions: []corev1.NodeCondition{condition(statusFalse, "")},
+			wantEvent:  "Normal ConditionDeleted Deleted AlertManager_NodeOnFire after it was resolved for longer than 10m0s",
+		},
+		{
+			name:       "heartbeat",
+			conditions: []corev1.NodeCondition{condition(statusTrue, "[P1] Node has erupted into fire at 500C")},
+			alerts:     nodeOnFire,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &mockAlertCache{}
+			cache.On("Get", "node1", "default").Return(tt.alerts, currentTime.Time, tt.fetchErr)
+			recorder := record.NewFakeRecorder(10)
+			r := NewNodeStatusReconciler(nil, logr.Discard(), prometheus.NewRegistry(), recorder, cache, ReconcilerOptions{Linger: 10 * time.Minute, Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}}).(*nodeStatusReconciler)
+
+			assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), newNode(tt.conditions...)))
+			if tt.wantEvent == "" {
+				assert.Equal(t, 0, len(recorder.Events))
+			} else {
+				assert.Equal(t, tt.wantEvent, <-recorder.Events)
+				assert.Equal(t, 0, len(recorder.Events))
+			}
+		})
+	}
+}
+
+func Test_updateNodeStatuses_fetchErrorKeep(t *testing.T) {
+	mockClient := &mockAlertCache{}
+	mockClient.On("Get", "node1", "default").Return(nil, currentTime.Time, errors.New("cannot get alerts"))
+	r := NewNodeStatusReconciler(nil, logr.Discard(), prometheus.NewRegistry(), record.NewFakeRecorder(10), mockClient, ReconcilerOptions{Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}, FetchErrorPolicy: FetchErrorKeep}).(*nodeStatusReconciler)
+
+	firing := corev1.NodeCondition{
+		Status:             "True",
+		Type:               "AlertManager_NodeOnFire",
+		Reason:             "AlertIsFiring",
+		Message:            "[P1]",
+		LastHeartbeatTime:  oldTime,
+		LastTransitionTime: oldTime,
+	}
+	node := newNode(corev1.NodeCondition{Type: "Ready", Status: "True"}, firing)
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	assert.DeepEqual(t, newNode(corev1.NodeCondition{Type: "Ready", Status: "True"}, firing), node)
+	mock.AssertExpectationsForObjects(t, mockClient)
+}
+
+func TestFetchErrorPolicy_UnmarshalText(t *testing.T) {
+	var policy FetchErrorPolicy
+	assert.NilError(t, policy.UnmarshalText([]byte("keep")))
+	assert.Equal(t, FetchErrorKeep, policy)
+	assert.NilError(t, policy.UnmarshalText([]byte("unknown")))
+	assert.Equal(t, FetchErrorUnknown, policy)
+	assert.Error(t, policy.UnmarshalText([]byte("Unknown")), `unknown fetch error policy "Unknown"`)
+}
+
+func TestSuppressedStatus_UnmarshalText(t *testing.T) {
+	var status SuppressedStatus
+	assert.NilError(t, status.UnmarshalText([]byte("Unknown")))
+	assert.Equal(t, SuppressedStatus(statusUnknown), status)
+	assert.NilError(t, status.UnmarshalText([]byte("True")))
+	assert.Equal(t, SuppressedStatus(statusTrue), status)
+	assert.Error(t, status.UnmarshalText([]byte("false")), `unknown suppressed status "false"`)
+}
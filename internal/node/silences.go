@@ -0,0 +1,127 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// silencesAnnotation holds a JSON list of the silences of alerts on the node
+	silencesAnnotation = "sciuro.cloudflare.com/silences"
+
+	reasonSilencedOnNode = "AlertIsSilencedOnNode"
+
+	eventReasonInvalidSilences = "InvalidSilences"
+)
+
+// localSilence silences the alerts of a node with the alertname, whose labels have
+// the values of all matchers, until it expires. At least one of them must be set.
+type localSilence struct {
+	Alertname string            `json:"alertname,omitempty"`
+	Matchers  map[string]string `json:"matchers,omitempty"`
+	ExpiresAt v1.Time           `json:"expiresAt"`
+	Comment   string            `json:"comment,omitempty"`
+}
+
+type localSilences []localSilence
+
+// matches returns true if s silences al
+func (s *localSilence) matches(al alert.Alert) bool {
+	if s.Alertname != "" && al.Labels[alertNameLabel] != model.LabelValue(s.Alertname) {
+		return false
+	}
+	for name, value := range s.Matchers {
+		if al.Labels[model.LabelName(name)] != model.LabelValue(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// message describes s for the NodeCondition of the alerts it silences
+func (s *localSilence) message() string {
+	if s.Comment == "" {
+		return fmt.Sprintf("Silenced on the node until %s", s.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("Silenced on the node until %s: %s", s.ExpiresAt.UTC().Format(time.RFC3339), s.Comment)
+}
+
+// match returns the first silence of al, or nil if it is not silenced
+func (s localSilences) match(al alert.Alert) *localSilence {
+	for i := range s {
+		if s[i].matches(al) {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+// readLocalSilences returns the silences of node that are active at now. Expired
+// silences are removed from the annotation, while silences matching every alert are
+// logged and ignored. A malformed annotation is logged, recorded as a Warning Event
+// and left for the operator to fix.
+func (n *nodeStatusReconciler) readLocalSilences(log logr.Logger, node *corev1.Node, now time.Time) localSilences {
+	raw, ok := node.Annotations[silencesAnnotation]
+	if !ok {
+		return nil
+	}
+	var silences localSilences
+	if err := json.Unmarshal([]byte(raw), &silences); err != nil {
+		log.Error(err, "ignoring malformed silences annotation", "annotation", raw)
+		n.recorder.Eventf(node, corev1.EventTypeWarning, eventReasonInvalidSilences,
+			"Ignored malformed %s annotation: %v", silencesAnnotation, err)
+		return nil
+	}
+
+	active := make(localSilences, 0, len(silences))
+	kept := make(localSilences, 0, len(silences))
+	for _, silence := range silences {
+		if !silence.ExpiresAt.After(now) {
+			log.Info("removing expired silence", "alertname", silence.Alertname, "matchers", silence.Matchers, "expiresAt", silence.ExpiresAt)
+			continue
+		}
+		kept = append(kept, silence)
+		if silence.Alertname == "" && len(silence.Matchers) == 0 {
+			log.Info("ignoring silence without alertname or matchers", "expiresAt", silence.ExpiresAt)
+			continue
+		}
+		active = append(active, silence)
+	}
+	if len(kept) == len(silences) {
+		return active
+	}
+	if len(kept) == 0 {
+		delete(node.Annotations, silencesAnnotation)
+		return active
+	}
+	updated, err := json.Marshal(kept)
+	if err != nil {
+		log.Error(err, "could not remove expired silences")
+		return active
+	}
+	node.Annotations[silencesAnnotation] = string(updated)
+	return active
+}
+
+// setLocalSilences counts the active silences of the named node
+func (n *nodeStatusReconciler) setLocalSilences(nodeName string, count int) {
+	n.silencesMu.Lock()
+	defer n.silencesMu.Unlock()
+	previous := n.silencesByNode[nodeName]
+	if previous == count {
+		return
+	}
+	if count == 0 {
+		delete(n.silencesByNode, nodeName)
+	} else {
+		n.silencesByNode[nodeName] = count
+	}
+	n.localSilences.Add(float64(count - previous))
+}
@@ -0,0 +1,184 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	"github.com/go-logr/logr"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestLocalSilence_matches(t *testing.T) {
+	al := alert.Alert{Alert: promv1.Alert{
+		Labels: model.LabelSet{"alertname": "NodeOnFire", "rack": "r1", "severity": "critical"},
+	}}
+	tests := []struct {
+		name    string
+		silence localSilence
+		want    bool
+	}{
+		{name: "alertname", silence: localSilence{Alertname: "NodeOnFire"}, want: true},
+		{name: "other alertname", silence: localSilence{Alertname: "NodeFlooded"}},
+		{name: "matchers", silence: localSilence{Matchers: map[string]string{"rack": "r1", "severity": "critical"}}, want: true},
+		{name: "one matcher differs", silence: localSilence{Matchers: map[string]string{"rack": "r1", "severity": "warning"}}},
+		{name: "missing label", silence: localSilence{Matchers: map[string]string{"zone": "z1"}}},
+		{name: "alertname and matchers", silence: localSilence{Alertname: "NodeOnFire", Matchers: map[string]string{"rack": "r2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.silence.matches(al))
+		})
+	}
+}
+
+func Test_readLocalSilences(t *testing.T) {
+	now := time.Date(2020, 3, 18, 13, 17, 58, 0, time.UTC)
+	tests := []struct {
+		name           string
+		annotation     string
+		wantAlertnames []string
+		wantAnnotation *string
+		wantEvent      string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:           "active silences",
+			annotation:     `[{"alertname":"NodeOnFire","expiresAt":"2020-03-18T14:00:00Z"},{"matchers":{"rack":"r1"},"expiresAt":"2020-03-18T15:00:00Z"}]`,
+			wantAlertnames: []string{"NodeOnFire", ""},
+			wantAnnotation: ptr(`[{"alertname":"NodeOnFire","expiresAt":"2020-03-18T14:00:00Z"},{"matchers":{"rack":"r1"},"expiresAt":"2020-03-18T15:00:00Z"}]`),
+		},
+		{
+			name:           "expired silences are removed",
+			annotation:     `[{"alertname":"NodeOnFire","expiresAt":"2020-03-18T13:00:00Z"},{"alertname":"NodeFlooded","expiresAt":"2020-03-18T14:00:00Z","comment":"flood defences"}]`,
+			wantAlertnames: []string{"NodeFlooded"},
+			wantAnnotation: ptr(`[{"alertname":"NodeFlooded","expiresAt":"2020-03-18T14:00:00Z","comment":"flood defences"}]`),
+		},
+		{
+			name:       "annotation of expired silences is removed",
+			annotation: `[{"alertname":"NodeOnFire","expiresAt":"2020-03-18T13:17:58Z"}]`,
+		},
+		{
+			name:           "silences matching everything are ignored",
+			annotation:     `[{"expiresAt":"2020-03-18T14:00:00Z"}]`,
+			wantAnnotation: ptr(`[{"expiresAt":"2020-03-18T14:00:00Z"}]`),
+		},
+		{
+			name:           "malformed annotation",
+			annotation:     `{"alertname":"NodeOnFire"}`,
+			wantAnnotation: ptr(`{"alertname":"NodeOnFire"}`),
+			wantEvent:      "Warning InvalidSilences Ignored malformed sciuro.cloudflare.com/silences annotation: json: cannot unmarshal object into Go value of type node.localSilences",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &nodeStatusReconciler{recorder: recorder}
+			node := newNode()
+			if tt.annotation != "" {
+				node.Annotations = map[string]string{silencesAnnotation: tt.annotation}
+			}
+
+			silences := r.readLocalSilences(logr.Discard(), node, now)
+			alertnames := make([]string, 0, len(silences))
+			for _, silence := range silences {
+				alertnames = append(alertnames, silence.Alertname)
+			}
+			if tt.wantAlertnames == nil {
+				tt.wantAlertnames = []string{}
+			}
+			assert.DeepEqual(t, tt.wantAlertnames, alertnames)
+			annotation, ok := node.Annotations[silencesAnnotation]
+			assert.Equal(t, tt.wantAnnotation != nil, ok)
+			if tt.wantAnnotation != nil {
+				assert.Equal(t, *tt.wantAnnotation, annotation)
+			}
+			if tt.wantEvent != "" {
+				assert.Equal(t, tt.wantEvent, <-recorder.Events)
+			}
+		})
+	}
+}
+
+func Test_updateNodeStatuses_silences(t *testing.T) {
+	firing := func(alertname, rack model.LabelValue) alert.Alert {
+		return alert.Alert{Alert: promv1.Alert{
+			State:  promv1.AlertStateFiring,
+			Labels: model.LabelSet{"alertname": alertname, "rack": rack, "priority": "1"},
+		}}
+	}
+	cache := &mockAlertCache{}
+	cache.On("Get", "node1", "default").Return([]alert.Alert{
+		firing("NodeOnFire", "r1"),
+		firing("NodeFlooded", "r1"),
+		firing("NodeMelting", "r2"),
+		// a firing alert that is not silenced keeps its condition firing
+		firing("NodeMelting", "r1"),
+	}, currentTime.Time, nil)
+	recorder := record.NewFakeRecorder(10)
+	r := NewNodeStatusReconciler(nil, logr.Discard(), prometheus.NewRegistry(), recorder, cache, ReconcilerOptions{Rules: []Rule{{Name: "default", ConditionPrefix: "AlertManager_"}}}).(*nodeStatusReconciler)
+
+	node := newNode(corev1.NodeCondition{
+		Type:               "AlertManager_NodeOnFire",
+		Status:             statusTrue,
+		Reason:             reasonFiring,
+		Message:            "[P1]",
+		LastHeartbeatTime:  oldTime,
+		LastTransitionTime: oldTime,
+	})
+	node.Annotations = map[string]string{
+		silencesAnnotation: `[{"alertname":"NodeOnFire","expiresAt":"2999-01-01T00:00:00Z","comment":"investigating"},` +
+			`{"matchers":{"rack":"r1"},"expiresAt":"2999-01-01T00:00:00Z"},` +
+			`{"alertname":"NodeOnFire","expiresAt":"2020-01-01T00:00:00Z"}]`,
+	}
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+
+	assert.DeepEqual(t, []corev1.NodeCondition{
+		{
+			Type:               "AlertManager_NodeOnFire",
+			Status:             statusFalse,
+			Reason:             reasonSilencedOnNode,
+			Message:            "Silenced on the node until 2999-01-01T00:00:00Z: investigating",
+			LastHeartbeatTime:  currentTime,
+			LastTransitionTime: currentTime,
+		},
+		{
+			Type:               "AlertManager_NodeMelting",
+			Status:             statusTrue,
+			Reason:             reasonFiring,
+			Message:            "[P1]",
+			LastHeartbeatTime:  currentTime,
+			LastTransitionTime: currentTime,
+		},
+		{
+			Type:               "AlertManager_NodeFlooded",
+			Status:             statusFalse,
+			Reason:             reasonSilencedOnNode,
+			Message:            "Silenced on the node until 2999-01-01T00:00:00Z",
+			LastHeartbeatTime:  currentTime,
+			LastTransitionTime: currentTime,
+		},
+	}, node.Status.Conditions)
+	assert.Equal(t, `[{"alertname":"NodeOnFire","expiresAt":"2999-01-01T00:00:00Z","comment":"investigating"},{"matchers":{"rack":"r1"},"expiresAt":"2999-01-01T00:00:00Z"}]`,
+		node.Annotations[silencesAnnotation])
+	assert.Equal(t, 2.0, testutil.ToFloat64(r.localSilences))
+	assert.Equal(t, "Normal ConditionSuppressed AlertManager_NodeOnFire is False as AlertIsSilencedOnNode: Silenced on the node until 2999-01-01T00:00:00Z: investigating", <-recorder.Events)
+
+	// removing the silences resolves the gauge
+	delete(node.Annotations, silencesAnnotation)
+	assert.NilError(t, r.updateNodeStatuses(context.Background(), logr.Discard(), node))
+	assert.Equal(t, 0.0, testutil.ToFloat64(r.localSilences))
+}
+
+func ptr(s string) *string {
+	return &s
+}
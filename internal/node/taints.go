@@ -0,0 +1,121 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// taintKeyPrefix marks the taints sciuro owns
+	taintKeyPrefix = "sciuro.cloudflare.com/"
+	// maxTaintNameLength is the longest name of a qualified taint key
+	maxTaintNameLength = 63
+)
+
+// Taints maps the effects of taints to the highest priority whose True NodeConditions
+// taint the node with that effect, e.g. NoSchedule for priorities up to 2 and
+// PreferNoSchedule up to 5. When both apply NoSchedule wins.
+//
+// The key of a taint is derived from the NodeConditionType, and the node is tainted
+// while the NodeCondition is True.
+type Taints map[corev1.TaintEffect]int
+
+func (t Taints) validate() error {
+	for effect := range t {
+		if effect != corev1.TaintEffectNoSchedule && effect != corev1.TaintEffectPreferNoSchedule {
+			return fmt.Errorf("taint effect must be %s or %s, not %q",
+				corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, effect)
+		}
+	}
+	return nil
+}
+
+// effect returns the effect of the taint for a True NodeCondition of priority, or
+// an empty effect if it does not taint
+func (t Taints) effect(priority int) corev1.TaintEffect {
+	if maxPriority, ok := t[corev1.TaintEffectNoSchedule]; ok && priority <= maxPriority {
+		return corev1.TaintEffectNoSchedule
+	}
+	if maxPriority, ok := t[corev1.TaintEffectPreferNoSchedule]; ok && priority <= maxPriority {
+		return corev1.TaintEffectPreferNoSchedule
+	}
+	return ""
+}
+
+// taintKey returns the key of the taint of conditionType, which is made to fit the name
+// of a qualified key
+func taintKey(conditionType corev1.NodeConditionType) string {
+	name := string(conditionType)
+	if len(name) > maxTaintNameLength {
+		name = strings.TrimRight(name[:maxTaintNameLength], "_.-")
+	}
+	return taintKeyPrefix + name
+}
+
+// updateTaints taints node for its True NodeConditions of rules with Taints, and removes
+// the taints sciuro added for NodeConditions that are no longer True. The taints of
+// NodeConditions whose alerts are unavailable, or whose resolution is held back, are
+// kept as they are.
+func updateTaints(log logr.Logger, node *corev1.Node, byRule []*ruleAlerts) {
+	existing := make(map[string]corev1.Taint)
+	for _, taint := range node.Spec.Taints {
+		if strings.HasPrefix(taint.Key, taintKeyPrefix) {
+			existing[taint.Key] = taint
+		}
+	}
+
+	desired := make(map[string]corev1.Taint)
+	for _, condition := range node.Status.Conditions {
+		ra := owner(byRule, condition.Type)
+		if ra == nil || ra.rule.Taints == nil || condition.Status == statusFalse {
+			continue
+		}
+		key := taintKey(condition.Type)
+		priority, firing := ra.firing[condition.Type]
+		if condition.Status == statusTrue && firing {
+			effect := ra.rule.Taints.effect(priority)
+			if effect != "" && desired[key].Effect != corev1.TaintEffectNoSchedule {
+				desired[key] = corev1.Taint{Key: key, Effect: effect}
+			}
+			continue
+		}
+		if taint, ok := existing[key]; ok {
+			if _, ok := desired[key]; !ok {
+				desired[key] = taint
+			}
+		}
+	}
+
+	taints := node.Spec.Taints[:0:0]
+	for _, taint := range node.Spec.Taints {
+		if !strings.HasPrefix(taint.Key, taintKeyPrefix) {
+			taints = append(taints, taint)
+			continue
+		}
+		updated, ok := desired[taint.Key]
+		if !ok {
+			log.Info("removing taint", "taint", taint.Key, "effect", taint.Effect)
+			continue
+		}
+		if updated.Effect != taint.Effect {
+			log.Info("changing effect of taint", "taint", taint.Key, "oldEffect", taint.Effect, "newEffect", updated.Effect)
+			taint.Effect = updated.Effect
+		}
+		taints = append(taints, taint)
+		delete(desired, taint.Key)
+	}
+	added := make([]string, 0, len(desired))
+	for key := range desired {
+		added = append(added, key)
+	}
+	sort.Strings(added)
+	for _, key := range added {
+		log.Info("adding taint", "taint", key, "effect", desired[key].Effect)
+		taints = append(taints, desired[key])
+	}
+	node.Spec.Taints = taints
+}
@@ -0,0 +1,135 @@
+package node
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTaints_effect(t *testing.T) {
+	taints := Taints{corev1.TaintEffectNoSchedule: 2, corev1.TaintEffectPreferNoSchedule: 5}
+	tests := []struct {
+		priority int
+		want     corev1.TaintEffect
+	}{
+		{priority: 1, want: corev1.TaintEffectNoSchedule},
+		{priority: 2, want: corev1.TaintEffectNoSchedule},
+		{priority: 3, want: corev1.TaintEffectPreferNoSchedule},
+		{priority: 5, want: corev1.TaintEffectPreferNoSchedule},
+		{priority: 6, want: ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, taints.effect(tt.priority), "priority %d", tt.priority)
+	}
+	assert.Equal(t, corev1.TaintEffect(""), Taints(nil).effect(1))
+}
+
+func TestTaints_validate(t *testing.T) {
+	assert.NilError(t, Taints(nil).validate())
+	assert.NilError(t, Taints{corev1.TaintEffectNoSchedule: 2, corev1.TaintEffectPreferNoSchedule: 5}.validate())
+	assert.ErrorContains(t, Taints{corev1.TaintEffectNoExecute: 1}.validate(), `not "NoExecute"`)
+	assert.ErrorContains(t, ValidateRules([]Rule{{
+		Name:            "default",
+		ConditionPrefix: "AlertManager_",
+		Taints:          Taints{corev1.TaintEffectNoExecute: 1},
+	}}), `rule "default": taint effect must be`)
+}
+
+func Test_taintKey(t *testing.T) {
+	assert.Equal(t, "sciuro.cloudflare.com/AlertManager_NodeOnFire", taintKey("AlertManager_NodeOnFire"))
+	long := taintKey(corev1.NodeConditionType("AlertManager_" + strings.Repeat("a", 49) + "_b"))
+	assert.Equal(t, "sciuro.cloudflare.com/AlertManager_"+strings.Repeat("a", 49), long)
+}
+
+func Test_updateTaints(t *testing.T) {
+	rule := Rule{Name: "default", ConditionPrefix: "AlertManager_", Taints: Taints{corev1.TaintEffectNoSchedule: 2, corev1.TaintEffectPreferNoSchedule: 5}}
+	foreign := corev1.Taint{Key: "example.com/maintenance", Effect: corev1.TaintEffectNoSchedule}
+	tests := []struct {
+		name       string
+		rule       Rule
+		conditions []corev1.NodeCondition
+		firing     map[corev1.NodeConditionType]int
+		taints     []corev1.Taint
+		want       []corev1.Taint
+	}{
+		{
+			name:       "firing condition taints",
+			rule:       rule,
+			conditions: []corev1.NodeCondition{{Type: "AlertManager_NodeOnFire", Status: statusTrue}},
+			firing:     map[corev1.NodeConditionType]int{"AlertManager_NodeOnFire": 1},
+			taints:     []corev1.Taint{foreign},
+			want: []corev1.Taint{
+				foreign,
+				{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectNoSchedule},
+			},
+		},
+		{
+			name:       "priority changes the effect",
+			rule:       rule,
+			conditions: []corev1.NodeCondition{{Type: "AlertManager_NodeOnFire", Status: statusTrue}},
+			firing:     map[corev1.NodeConditionType]int{"AlertManager_NodeOnFire": 4},
+			taints:     []corev1.Taint{{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectNoSchedule}},
+			want:       []corev1.Taint{{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectPreferNoSchedule}},
+		},
+		{
+			name:       "unimportant priority does not taint",
+			rule:       rule,
+			conditions: []corev1.NodeCondition{{Type: "AlertManager_NodeOnFire", Status: statusTrue}},
+			firing:     map[corev1.NodeConditionType]int{"AlertManager_NodeOnFire": 9},
+		},
+		{
+			name:       "rule without taints does not taint",
+			rule:       Rule{Name: "default", ConditionPrefix: "AlertManager_"},
+			conditions: []corev1.NodeCondition{{Type: "AlertManager_NodeOnFire", Status: statusTrue}},
+			firing:     map[corev1.NodeConditionType]int{"AlertManager_NodeOnFire": 1},
+		},
+		{
+			name:       "False condition removes the taint",
+			rule:       rule,
+			conditions: []corev1.NodeCondition{{Type: "AlertManager_NodeOnFire", Status: statusFalse}},
+			taints: []corev1.Taint{
+				{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectNoSchedule},
+				foreign,
+			},
+			want: []corev1.Taint{foreign},
+		},
+		{
+			name:   "deleted condition removes the taint",
+			rule:   rule,
+			taints: []corev1.Taint{{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectNoSchedule}},
+			want:   []corev1.Taint{},
+		},
+		{
+			name:       "Unknown condition keeps the taint",
+			rule:       rule,
+			conditions: []corev1.NodeCondition{{Type: "AlertManager_NodeOnFire", Status: statusUnknown}},
+			taints:     []corev1.Taint{{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectNoSchedule}},
+			want:       []corev1.Taint{{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectNoSchedule}},
+		},
+		{
+			name:       "held back resolution keeps the taint",
+			rule:       rule,
+			conditions: []corev1.NodeCondition{{Type: "AlertManager_NodeOnFire", Status: statusTrue}},
+			taints:     []corev1.Taint{{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectPreferNoSchedule}},
+			want:       []corev1.Taint{{Key: "sciuro.cloudflare.com/AlertManager_NodeOnFire", Effect: corev1.TaintEffectPreferNoSchedule}},
+		},
+		{
+			name:       "conditions of other owners are ignored",
+			rule:       rule,
+			conditions: []corev1.NodeCondition{{Type: "Ready", Status: statusTrue}},
+			taints:     []corev1.Taint{foreign},
+			want:       []corev1.Taint{foreign},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := newNode(tt.conditions...)
+			node.Spec.Taints = tt.taints
+			updateTaints(logr.Discard(), node, []*ruleAlerts{{rule: tt.rule, firing: tt.firing}})
+			assert.DeepEqual(t, tt.want, node.Spec.Taints)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultTypeTemplate names conditions after the alert
+	DefaultTypeTemplate = `{{ .Labels.alertname }}`
+	// DefaultReasonTemplate is the reason of every firing alert
+	DefaultReasonTemplate = reasonFiring
+	// DefaultMessageTemplate is the priority of the alert followed by its summary
+	DefaultMessageTemplate = `[P{{ .Priority }}]{{ with .Annotations.summary }} {{ . }}{{ end }}`
+
+	// maxConditionTypeLength is the longest condition type accepted by the API
+	// for metav1.Condition, which node conditions are held to as well
+	maxConditionTypeLength = 316
+)
+
+// invalidTypeChars are the characters not allowed in a condition type
+var invalidTypeChars = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+
+// ConditionTemplates render the NodeConditionType suffix, reason and message of the
+// NodeCondition of a firing alert. Each is a text/template executed with:
+//
+//	.Labels       map of the alert's labels
+//	.Annotations  map of the alert's annotations
+//	.Priority     the priority of the alert
+//
+// Missing labels and annotations render as empty strings.
+type ConditionTemplates struct {
+	typ     *template.Template
+	reason  *template.Template
+	message *template.Template
+}
+
+// templateData is what a template is executed with
+type templateData struct {
+	Labels      map[string]string
+	Annotations map[string]string
+	Priority    int
+}
+
+// ParseConditionTemplates parses the templates and checks that they can be executed,
+// so that a broken template fails at startup rather than on every reconcile
+func ParseConditionTemplates(typ, reason, message string) (*ConditionTemplates, error) {
+	var ct ConditionTemplates
+	for _, t := range []struct {
+		name string
+		text string
+		dst  **template.Template
+	}{
+		{name: "type", text: typ, dst: &ct.typ},
+		{name: "reason", text: reason, dst: &ct.reason},
+		{name: "message", text: message, dst: &ct.message},
+	} {
+		parsed, err := template.New(t.name).Option("missingkey=zero").Parse(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", t.name, err)
+		}
+		if err := parsed.Execute(&bytes.Buffer{}, templateData{}); err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", t.name, err)
+		}
+		*t.dst = parsed
+	}
+	return &ct, nil
+}
+
+// defaultTemplates reproduce the conditions of the default templates
+var defaultTemplates = mustParseConditionTemplates(DefaultTypeTemplate, DefaultReasonTemplate, DefaultMessageTemplate)
+
+func mustParseConditionTemplates(typ, reason, message string) *ConditionTemplates {
+	ct, err := ParseConditionTemplates(typ, reason, message)
+	if err != nil {
+		panic(err)
+	}
+	return ct
+}
+
+// render returns the condition type, reason and message of al
+func (ct *ConditionTemplates) render(al alert.Alert, conditionPrefix string, priority int) (corev1.NodeConditionType, string, string, error) {
+	data := templateData{
+		Labels:      make(map[string]string, len(al.Labels)),
+		Annotations: make(map[string]string, len(al.Annotations)),
+		Priority:    priority,
+	}
+	for k, v := range al.Labels {
+		data.Labels[string(k)] = string(v)
+	}
+	for k, v := range al.Annotations {
+		data.Annotations[string(k)] = string(v)
+	}
+
+	var rendered [3]string
+	for i, t := range []*template.Template{ct.typ, ct.reason, ct.message} {
+		var buf strings.Builder
+		if err := t.Execute(&buf, data); err != nil {
+			return "", "", "", fmt.Errorf("could not render %s template: %w", t.Name(), err)
+		}
+		rendered[i] = buf.String()
+	}
+
+	conditionType := sanitizeConditionType(conditionPrefix + rendered[0])
+	if !strings.HasPrefix(conditionType, conditionPrefix) || conditionType == conditionPrefix {
+		return "", "", "", fmt.Errorf("type template rendered an invalid condition type %q", rendered[0])
+	}
+	return corev1.NodeConditionType(conditionType), rendered[1], rendered[2], nil
+}
+
+// sanitizeConditionType replaces runs of characters that are not valid in a
+// condition type with an underscore, strips leading and trailing characters that
+// are not alphanumeric and truncates the result to the maximum length
+func sanitizeConditionType(conditionType string) string {
+	sanitized := invalidTypeChars.ReplaceAllString(conditionType, "_")
+	if len(sanitized) > maxConditionTypeLength {
+		sanitized = sanitized[:maxConditionTypeLength]
+	}
+	return strings.TrimFunc(sanitized, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	})
+}
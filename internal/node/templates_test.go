@@ -0,0 +1,139 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/cloudflare/sciuro/internal/alert"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseConditionTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     string
+		reason  string
+		message string
+		wantErr string
+	}{
+		{
+			name:    "defaults",
+			typ:     DefaultTypeTemplate,
+			reason:  DefaultReasonTemplate,
+			message: DefaultMessageTemplate,
+		},
+		{
+			name:    "unclosed action",
+			typ:     `{{ .Labels.alertname`,
+			reason:  DefaultReasonTemplate,
+			message: DefaultMessageTemplate,
+			wantErr: "invalid type template: template: type:1: unclosed action",
+		},
+		{
+			name:    "unknown field",
+			typ:     DefaultTypeTemplate,
+			reason:  `{{ .Severity }}`,
+			message: DefaultMessageTemplate,
+			wantErr: `invalid reason template: template: reason:1:3: executing "reason" at <.Severity>: can't evaluate field Severity in type node.templateData`,
+		},
+		{
+			name:    "unknown function",
+			typ:     DefaultTypeTemplate,
+			reason:  DefaultReasonTemplate,
+			message: `{{ title .Annotations.summary }}`,
+			wantErr: `invalid message template: template: message:1: function "title" not defined`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConditionTemplates(tt.typ, tt.reason, tt.message)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func Test_convertAlertToCondition_templates(t *testing.T) {
+	templates, err := ParseConditionTemplates(
+		`{{ .Labels.alertname }}_{{ .Labels.device }}`,
+		`{{ .Labels.alertname }}Firing`,
+		`{{ .Labels.device }}: {{ .Annotations.summary }} (P{{ .Priority }})`,
+	)
+	assert.NilError(t, err)
+	rule := Rule{Name: "default", ConditionPrefix: "AlertManager_", Templates: templates}
+
+	tests := []struct {
+		name   string
+		labels model.LabelSet
+		want   *corev1.NodeCondition
+	}{
+		{
+			name:   "rendered",
+			labels: model.LabelSet{"alertname": "NodeDiskPressure", "device": "sda", "priority": "2"},
+			want: &corev1.NodeCondition{
+				Type:    "AlertManager_NodeDiskPressure_sda",
+				Reason:  "NodeDiskPressureFiring",
+				Message: "sda: Disk is full (P2)",
+			},
+		},
+		{
+			name:   "sanitized",
+			labels: model.LabelSet{"alertname": "NodeDiskPressure", "device": "/dev/nvme0n1 p1/"},
+			want: &corev1.NodeCondition{
+				Type:    "AlertManager_NodeDiskPressure__dev_nvme0n1_p1",
+				Reason:  "NodeDiskPressureFiring",
+				Message: "/dev/nvme0n1 p1/: Disk is full (P9)",
+			},
+		},
+		{
+			name:   "missing label",
+			labels: model.LabelSet{"alertname": "NodeDiskPressure"},
+			want: &corev1.NodeCondition{
+				Type:    "AlertManager_NodeDiskPressure",
+				Reason:  "NodeDiskPressureFiring",
+				Message: ": Disk is full (P9)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			al := alert.Alert{Alert: promv1.Alert{
+				State:       promv1.AlertStateFiring,
+				Labels:      tt.labels,
+				Annotations: model.LabelSet{"summary": "Disk is full"},
+			}}
+			priority, _, err := DefaultPriorities.of(al)
+			assert.NilError(t, err)
+			got, err := convertAlertToCondition(al, currentTime, rule, priority)
+			assert.NilError(t, err)
+			tt.want.Status = statusTrue
+			tt.want.LastHeartbeatTime = currentTime
+			tt.want.LastTransitionTime = currentTime
+			assert.DeepEqual(t, tt.want, got.condition)
+		})
+	}
+
+	empty, err := ParseConditionTemplates(`{{ .Labels.component }}`, DefaultReasonTemplate, DefaultMessageTemplate)
+	assert.NilError(t, err)
+	al := alert.Alert{Alert: promv1.Alert{Labels: model.LabelSet{"alertname": "NodeOnFire"}}}
+	_, err = convertAlertToCondition(al, currentTime, Rule{ConditionPrefix: "AlertManager_", Templates: empty}, DefaultPriority)
+	assert.Error(t, err, `type template rendered an invalid condition type ""`)
+}
+
+func Test_sanitizeConditionType(t *testing.T) {
+	tests := map[string]string{
+		"AlertManager_NodeOnFire":           "AlertManager_NodeOnFire",
+		"AlertManager_Node On Fire":         "AlertManager_Node_On_Fire",
+		"AlertManager_disk{device=\"sda\"}": "AlertManager_disk_device_sda",
+		"AlertManager_node-1.example.com":   "AlertManager_node-1.example.com",
+		"_AlertManager_":                    "AlertManager",
+	}
+	for input, want := range tests {
+		assert.Equal(t, want, sanitizeConditionType(input), input)
+	}
+}